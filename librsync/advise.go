@@ -0,0 +1,11 @@
+package librsync
+
+// Advice is an access pattern hint for a file, see AdviseFile.
+type Advice int
+
+const (
+	AdviceNone       Advice = iota // don't give any advice
+	AdviceNormal                   // no particular access pattern, the default of the system
+	AdviceSequential               // the file is read from start to end
+	AdviceRandom                   // the file is read at random offsets, like a basis when patching
+)
@@ -0,0 +1,38 @@
+package librsync
+
+/*
+#include <fcntl.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// AdviseFile tells the kernel how f is going to be read with posix_fadvise,
+// e.g. AdviceRandom for a basis, as the patch callback reads it at the offsets
+// the delta copies from. This only tunes readahead and caching. On systems
+// without posix_fadvise, it does nothing.
+func AdviseFile(f *os.File, advice Advice) error {
+	var a C.int
+	switch advice {
+	case AdviceNone:
+		return nil
+	case AdviceNormal:
+		a = C.POSIX_FADV_NORMAL
+	case AdviceSequential:
+		a = C.POSIX_FADV_SEQUENTIAL
+	case AdviceRandom:
+		a = C.POSIX_FADV_RANDOM
+	default:
+		return fmt.Errorf("Unknown advice %d", advice)
+	}
+
+	// posix_fadvise returns the error number instead of setting errno.
+	if res := C.posix_fadvise(C.int(f.Fd()), 0, 0, a); res != 0 {
+		return &os.PathError{Op: "fadvise", Path: f.Name(), Err: syscall.Errno(res)}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package librsync
+
+import (
+	"os"
+)
+
+// AdviseFile would tell the system how f is going to be read, but this system
+// has no posix_fadvise, so it does nothing.
+func AdviseFile(f *os.File, advice Advice) error {
+	return nil
+}
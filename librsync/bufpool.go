@@ -0,0 +1,83 @@
+package librsync
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Jobs with the default buffer sizes take their buffers from a pool of C
+// buffers and return them on Close, to save the malloc and free calls when
+// many jobs are created. The C memory is not managed by the garbage
+// collector, so the pool is bounded explicitly.
+
+// DefaultBufferPoolSize is the number of buffers the pool keeps by default.
+const DefaultBufferPoolSize = 16
+
+// pooledBufSize is the size of the pooled buffers.
+const pooledBufSize = inbufSize
+
+var bufferPool = struct {
+	lock sync.Mutex
+	bufs []unsafe.Pointer
+	max  int
+}{
+	max: DefaultBufferPoolSize,
+}
+
+// SetBufferPoolSize sets the maximum number of buffers kept for reuse by
+// following jobs. Each buffer takes 16 KiB. 0 disables the pool.
+func SetBufferPoolSize(n int) {
+	bufferPool.lock.Lock()
+	defer bufferPool.lock.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	bufferPool.max = n
+	for len(bufferPool.bufs) > n {
+		last := len(bufferPool.bufs) - 1
+		C.free(bufferPool.bufs[last])
+		bufferPool.bufs = bufferPool.bufs[:last]
+	}
+}
+
+// allocBuffer returns a C buffer of the given size, from the pool if possible.
+func allocBuffer(size int) unsafe.Pointer {
+	if size == pooledBufSize {
+		bufferPool.lock.Lock()
+		if last := len(bufferPool.bufs) - 1; last >= 0 {
+			buf := bufferPool.bufs[last]
+			bufferPool.bufs = bufferPool.bufs[:last]
+			bufferPool.lock.Unlock()
+			return buf
+		}
+		bufferPool.lock.Unlock()
+	}
+
+	return C.malloc(C.size_t(size))
+}
+
+// releaseBuffer returns a buffer from allocBuffer to the pool, or frees it if
+// the pool is full.
+func releaseBuffer(buf unsafe.Pointer, size int) {
+	if buf == nil {
+		return
+	}
+
+	if size == pooledBufSize {
+		bufferPool.lock.Lock()
+		defer bufferPool.lock.Unlock()
+
+		if len(bufferPool.bufs) < bufferPool.max {
+			bufferPool.bufs = append(bufferPool.bufs, buf)
+			return
+		}
+	}
+
+	C.free(buf)
+}
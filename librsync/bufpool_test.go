@@ -0,0 +1,56 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	defer SetBufferPoolSize(DefaultBufferPoolSize)
+	SetBufferPoolSize(1)
+
+	a := allocBuffer(pooledBufSize)
+	b := allocBuffer(pooledBufSize)
+	releaseBuffer(a, pooledBufSize)
+	releaseBuffer(b, pooledBufSize) // pool is full, freed
+
+	if allocBuffer(pooledBufSize) != a {
+		t.Errorf("released buffer was not reused")
+	}
+	releaseBuffer(a, pooledBufSize)
+
+	SetBufferPoolSize(0)
+	if len(bufferPool.bufs) != 0 {
+		t.Errorf("pool still holds %d buffers after disabling it", len(bufferPool.bufs))
+	}
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	data := testdata.RandomData()[:100]
+
+	for _, bench := range []struct {
+		name string
+		size int
+	}{
+		{"unpooled", 0},
+		{"pooled", DefaultBufferPoolSize},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			SetBufferPoolSize(bench.size)
+			defer SetBufferPoolSize(DefaultBufferPoolSize)
+
+			for i := 0; i < b.N; i++ {
+				siggen, err := NewSignatureGen(Config{}, bytes.NewReader(data))
+				if err != nil {
+					b.Fatalf("NewSignatureGen failed: %s", err)
+				}
+				if _, err := io.Copy(io.Discard, siggen); err != nil {
+					b.Fatalf("signature generation failed: %s", err)
+				}
+				siggen.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package librsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// A bundle packs a signature and the corresponding delta into one stream. It
+// starts with a header of
+//
+//	magic     4 bytes  "RSBN"
+//	version   1 byte   currently 1
+//	sig len   8 bytes  big endian
+//	delta len 8 bytes  big endian
+//	checksum  4 bytes  big endian CRC-32 (IEEE) of the preceding header bytes
+//
+// followed by the signature and then the delta.
+
+const (
+	bundleMagic     = "RSBN"
+	bundleVersion   = 1
+	bundleHeaderLen = 25
+)
+
+var (
+	ErrBadBundle     = errors.New("Not a bundle or corrupted bundle header")
+	ErrBundleVersion = errors.New("Unsupported bundle version")
+)
+
+// WriteBundle writes sig and delta as a bundle to w.
+func WriteBundle(w io.Writer, sig, delta []byte) error {
+	var header [bundleHeaderLen]byte
+	copy(header[:], bundleMagic)
+	header[4] = bundleVersion
+	binary.BigEndian.PutUint64(header[5:], uint64(len(sig)))
+	binary.BigEndian.PutUint64(header[13:], uint64(len(delta)))
+	binary.BigEndian.PutUint32(header[21:], crc32.ChecksumIEEE(header[:21]))
+
+	for _, b := range [][]byte{header[:], sig, delta} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBundle reads the header of a bundle and returns readers for the
+// signature and the delta. Both are read from r as needed, so the signature
+// should be read first. Reading the delta skips any unread signature data.
+func ReadBundle(r io.Reader) (sig, delta io.Reader, err error) {
+	var header [bundleHeaderLen]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrBadBundle
+		}
+		return
+	}
+
+	if string(header[:4]) != bundleMagic || binary.BigEndian.Uint32(header[21:]) != crc32.ChecksumIEEE(header[:21]) {
+		return nil, nil, ErrBadBundle
+	}
+	if header[4] != bundleVersion {
+		return nil, nil, ErrBundleVersion
+	}
+
+	sigLen := int64(binary.BigEndian.Uint64(header[5:]))
+	deltaLen := int64(binary.BigEndian.Uint64(header[13:]))
+	if sigLen < 0 || deltaLen < 0 {
+		return nil, nil, ErrBadBundle
+	}
+
+	sigr := &sectionReader{r: r, n: sigLen}
+	return sigr, &bundleDelta{sig: sigr, delta: &sectionReader{r: r, n: deltaLen}}, nil
+}
+
+// sectionReader reads the next n bytes of r. Unlike io.LimitedReader, it
+// reports ErrInputEnded if r ends early.
+type sectionReader struct {
+	r io.Reader
+	n int64
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.n {
+		p = p[:s.n]
+	}
+
+	n, err := s.r.Read(p)
+	s.n -= int64(n)
+	if err == io.EOF && s.n > 0 {
+		err = ErrInputEnded
+	}
+	return n, err
+}
+
+type bundleDelta struct {
+	sig   *sectionReader
+	delta *sectionReader
+}
+
+func (b *bundleDelta) Read(p []byte) (int, error) {
+	if b.sig.n > 0 {
+		if _, err := io.Copy(io.Discard, b.sig); err != nil {
+			return 0, err
+		}
+	}
+	return b.delta.Read(p)
+}
@@ -0,0 +1,53 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	sig := testdata.RandomDataSig()[0]
+	delta := testdata.Delta()
+
+	buf := new(bytes.Buffer)
+	if err := WriteBundle(buf, sig, delta); err != nil {
+		t.Fatalf("WriteBundle failed: %s", err)
+	}
+	bundle := buf.Bytes()
+
+	sigr, deltar, err := ReadBundle(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("ReadBundle failed: %s", err)
+	}
+	if got, err := io.ReadAll(sigr); err != nil || !bytes.Equal(got, sig) {
+		t.Errorf("signature does not match (error: %v)", err)
+	}
+	if got, err := io.ReadAll(deltar); err != nil || !bytes.Equal(got, delta) {
+		t.Errorf("delta does not match (error: %v)", err)
+	}
+
+	// Reading the delta first skips the signature.
+	_, deltar, err = ReadBundle(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("ReadBundle failed: %s", err)
+	}
+	if got, err := io.ReadAll(deltar); err != nil || !bytes.Equal(got, delta) {
+		t.Errorf("delta read without signature does not match (error: %v)", err)
+	}
+
+	corrupt := append([]byte(nil), bundle...)
+	corrupt[10] ^= 0xff
+	if _, _, err := ReadBundle(bytes.NewReader(corrupt)); err != ErrBadBundle {
+		t.Errorf("corrupted header: expected %s, got %v", ErrBadBundle, err)
+	}
+
+	_, deltar, err = ReadBundle(bytes.NewReader(bundle[:len(bundle)-1]))
+	if err != nil {
+		t.Fatalf("ReadBundle failed: %s", err)
+	}
+	if _, err := io.ReadAll(deltar); err != ErrInputEnded {
+		t.Errorf("truncated bundle: expected %s, got %v", ErrInputEnded, err)
+	}
+}
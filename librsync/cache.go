@@ -0,0 +1,122 @@
+package librsync
+
+import (
+	"container/list"
+	"sync"
+)
+
+// SignatureCache keeps loaded signatures for reuse, e.g. by a server that
+// serves deltas against the same basis files again and again. It holds up to a
+// fixed number of signatures and evicts the least recently used ones.
+//
+// Signatures returned by Get are reference counted: each has to be given back
+// with Release once it is not used anymore. An evicted signature is closed
+// once all references are released. A SignatureCache is safe for concurrent
+// use.
+type SignatureCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	lru        list.List // of *cacheEntry, most recently used first
+	byKey      map[string]*list.Element
+	bySig      map[*signature]*cacheEntry
+}
+
+type cacheEntry struct {
+	key     string
+	sig     Signature
+	refs    int
+	evicted bool
+}
+
+// NewSignatureCache creates a cache holding up to maxEntries signatures, or an
+// unlimited number if maxEntries <= 0.
+func NewSignatureCache(maxEntries int) *SignatureCache {
+	return &SignatureCache{
+		maxEntries: maxEntries,
+		byKey:      make(map[string]*list.Element),
+		bySig:      make(map[*signature]*cacheEntry),
+	}
+}
+
+// Get returns the signature cached under key, if any. It must be given back
+// with Release and not be closed by the caller.
+func (c *SignatureCache) Get(key string) (Signature, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.byKey[key]
+	if !ok {
+		return Signature{}, false
+	}
+	c.lru.MoveToFront(elem)
+
+	entry := elem.Value.(*cacheEntry)
+	entry.refs++
+	return entry.sig, true
+}
+
+// Release gives back a signature returned by Get.
+func (c *SignatureCache) Release(sig Signature) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.bySig[sig.signature]
+	if !ok || entry.refs == 0 {
+		return
+	}
+	entry.refs--
+	c.closeIfUnused(entry)
+}
+
+// Put adds sig to the cache under key, replacing a signature already cached
+// under it. The cache takes over sig and closes it when it is evicted, so
+// the caller must not close it, and must not put it into the cache again.
+func (c *SignatureCache) Put(key string, sig Signature) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.byKey[key]; ok {
+		c.evict(elem)
+	}
+
+	entry := &cacheEntry{key: key, sig: sig}
+	c.byKey[key] = c.lru.PushFront(entry)
+	c.bySig[sig.signature] = entry
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.evict(c.lru.Back())
+	}
+}
+
+// Len returns the number of cached signatures.
+func (c *SignatureCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close evicts all signatures. Those still in use are closed once they are
+// released.
+func (c *SignatureCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *SignatureCache) evict(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.byKey, entry.key)
+	entry.evicted = true
+	c.closeIfUnused(entry)
+}
+
+func (c *SignatureCache) closeIfUnused(entry *cacheEntry) {
+	if entry.evicted && entry.refs == 0 {
+		delete(c.bySig, entry.sig.signature)
+		entry.sig.Close()
+	}
+}
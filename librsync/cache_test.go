@@ -0,0 +1,52 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"testing"
+)
+
+func TestSignatureCache(t *testing.T) {
+	load := func() Signature {
+		sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+		if err != nil {
+			t.Fatalf("LoadSignature failed: %s", err)
+		}
+		return sig
+	}
+
+	cache := NewSignatureCache(2)
+	a, b, c := load(), load(), load()
+	cache.Put("a", a)
+	cache.Put("b", b)
+
+	got, ok := cache.Get("a")
+	if !ok || got.signature != a.signature {
+		t.Fatalf("Get did not return the cached signature")
+	}
+
+	// "b" is the least recently used one now.
+	cache.Put("c", c)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("least recently used signature was not evicted")
+	}
+	if !b.closed() {
+		t.Errorf("evicted signature was not closed")
+	}
+
+	// "a" is in use, so it is only closed once released.
+	cache.Close()
+	if cache.Len() != 0 {
+		t.Errorf("cache not empty after Close")
+	}
+	if a.closed() {
+		t.Errorf("signature in use was closed")
+	}
+	if !c.closed() {
+		t.Errorf("unused signature was not closed")
+	}
+	cache.Release(got)
+	if !a.closed() {
+		t.Errorf("released signature was not closed")
+	}
+}
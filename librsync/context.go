@@ -0,0 +1,46 @@
+package librsync
+
+import (
+	"context"
+	"io"
+)
+
+// Constructors for jobs that can be cancelled. Once the context is done, Read
+// stops processing and returns an error wrapping ctx.Err(), for this and all
+// following calls.
+
+// NewSignatureGenContext is like NewSignatureGen, but the job is cancelled
+// when ctx is done.
+func NewSignatureGenContext(ctx context.Context, config Config, basis io.Reader) (*Job, error) {
+	job, err := NewSignatureGen(config, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	job.ctx = ctx
+	return job, nil
+}
+
+// NewDeltaGenContext is like NewDeltaGen, but the job is cancelled when ctx is
+// done.
+func NewDeltaGenContext(ctx context.Context, sig Signature, newfile io.Reader) (*Job, error) {
+	job, err := NewDeltaGen(sig, newfile)
+	if err != nil {
+		return nil, err
+	}
+
+	job.ctx = ctx
+	return job, nil
+}
+
+// NewPatcherContext is like NewPatcher, but the job is cancelled when ctx is
+// done.
+func NewPatcherContext(ctx context.Context, delta io.Reader, basis io.ReaderAt) (*Patcher, error) {
+	patcher, err := NewPatcher(delta, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	patcher.ctx = ctx
+	return patcher, nil
+}
@@ -0,0 +1,35 @@
+package librsync
+
+import (
+	"io"
+)
+
+// CountingWriter counts the bytes written through it to W, e.g. to measure the
+// size of a signature or delta. If W is nil, the data is discarded.
+type CountingWriter struct {
+	N int64 // bytes written so far
+	W io.Writer
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	if c.W == nil {
+		c.N += int64(len(p))
+		return len(p), nil
+	}
+
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// CountingReader counts the bytes read through it from R.
+type CountingReader struct {
+	N int64 // bytes read so far
+	R io.Reader
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
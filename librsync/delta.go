@@ -0,0 +1,617 @@
+package librsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decoding of the delta format. A delta starts with a magic number, followed
+// by a sequence of commands, terminated by an end command. All integers are
+// big endian. See prototab.c in the librsync sources.
+
+// DeltaMagic is the magic number of deltas.
+const DeltaMagic = 0x72730236
+
+const (
+	opEnd           = 0x00
+	opLiteralMaxImm = 0x40 // literals of length 1 to 64 encode it in the opcode
+	opLiteralN1     = 0x41 // 0x41 - 0x44: literal with 1, 2, 4 or 8 byte length
+	opCopyN1N1      = 0x45 // 0x45 - 0x54: copy with 1, 2, 4 or 8 byte offset and length
+	opCopyN8N8      = 0x54
+)
+
+type deltaOp int
+
+const (
+	opCopy deltaOp = iota
+	opLiteral
+)
+
+// deltaCommand is a single command of a delta.
+type deltaCommand struct {
+	op     deltaOp
+	offset int64 // position in the basis, only for opCopy
+	length int64
+}
+
+// deltaDecoder reads the commands of a delta one by one.
+type deltaDecoder struct {
+	r       *bufio.Reader
+	started bool
+	ended   bool
+	literal int64 // unread literal data of the current command
+}
+
+func newDeltaDecoder(delta io.Reader) *deltaDecoder {
+	return &deltaDecoder{r: bufio.NewReader(delta)}
+}
+
+// readInt reads a big endian unsigned integer of the given width.
+func (d *deltaDecoder) readInt(width int) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[8-width:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, ErrInputEnded
+		}
+		return 0, err
+	}
+
+	n := int64(binary.BigEndian.Uint64(buf[:]))
+	if n < 0 {
+		return 0, ErrCorrupt
+	}
+	return n, nil
+}
+
+// next returns the next command of the delta. Literal data of the previous
+// command that was not read is skipped. After the end command, io.EOF is
+// returned.
+func (d *deltaDecoder) next() (cmd deltaCommand, err error) {
+	if d.ended {
+		return cmd, io.EOF
+	}
+
+	if !d.started {
+		magic, err := d.readInt(4)
+		if err != nil {
+			return cmd, err
+		}
+		if magic != DeltaMagic {
+			return cmd, ErrBadMagic
+		}
+		d.started = true
+	}
+
+	if d.literal > 0 {
+		if _, err = io.CopyN(io.Discard, d.r, d.literal); err != nil {
+			if err == io.EOF {
+				err = ErrInputEnded
+			}
+			return
+		}
+		d.literal = 0
+	}
+
+	op, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = ErrInputEnded
+		}
+		return
+	}
+
+	switch {
+	case op == opEnd:
+		d.ended = true
+		return cmd, io.EOF
+	case op <= opLiteralMaxImm:
+		cmd = deltaCommand{op: opLiteral, length: int64(op)}
+	case op < opCopyN1N1:
+		cmd.op = opLiteral
+		if cmd.length, err = d.readInt(1 << (op - opLiteralN1)); err != nil {
+			return
+		}
+	case op <= opCopyN8N8:
+		i := op - opCopyN1N1
+		cmd.op = opCopy
+		if cmd.offset, err = d.readInt(1 << (i / 4)); err != nil {
+			return
+		}
+		if cmd.length, err = d.readInt(1 << (i % 4)); err != nil {
+			return
+		}
+	default:
+		return cmd, ErrCorrupt
+	}
+
+	if cmd.op == opLiteral {
+		d.literal = cmd.length
+	}
+	return
+}
+
+// Read reads the literal data of the current command.
+func (d *deltaDecoder) Read(p []byte) (int, error) {
+	if d.literal == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > d.literal {
+		p = p[:d.literal]
+	}
+
+	n, err := d.r.Read(p)
+	d.literal -= int64(n)
+	if err == io.EOF && d.literal > 0 {
+		err = ErrInputEnded
+	}
+	return n, err
+}
+
+// IsNoOpDelta reports whether delta leaves its basis of basisSize bytes
+// unchanged, i.e. it consists of nothing but copies of the whole basis in
+// order. The delta is read once.
+//
+// This is not the same as a small delta: a delta with a handful of literal
+// bytes is small, but still describes a changed file. A delta does not record
+// the size of its basis, so it has to be given: A delta that copies only a
+// prefix of the basis truncates the file, and an empty delta empties it, so
+// they are only no-ops if the basis is exactly that long.
+//
+// Several copy commands that together copy the whole basis in order are a
+// no-op as well, not only a single one.
+func IsNoOpDelta(delta io.Reader, basisSize int64) (bool, error) {
+	if basisSize < 0 {
+		return false, errors.New("Negative basis size")
+	}
+
+	dec := newDeltaDecoder(delta)
+
+	var pos int64 // end of the basis copied so far
+	for {
+		cmd, err := dec.next()
+		switch {
+		case err == io.EOF:
+			return pos == basisSize, nil
+		case err != nil:
+			return false, err
+		case cmd.op == opLiteral, cmd.offset != pos, cmd.length > basisSize-pos:
+			return false, nil
+		}
+		pos += cmd.length
+	}
+}
+
+// deltaOutputSize returns the size of the file delta produces, the sum of the
+// lengths of its commands.
+func deltaOutputSize(delta io.Reader) (int64, error) {
+	dec := newDeltaDecoder(delta)
+
+	var size int64
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if size += cmd.length; size < 0 {
+			return 0, ErrCorrupt
+		}
+	}
+}
+
+// DeltaOp is the kind of a delta command.
+type DeltaOp int
+
+const (
+	DeltaCopy    DeltaOp = iota // copy data from the basis
+	DeltaLiteral                // insert literal data from the delta
+)
+
+func (op DeltaOp) String() string {
+	switch op {
+	case DeltaCopy:
+		return "COPY"
+	case DeltaLiteral:
+		return "LITERAL"
+	}
+	return fmt.Sprintf("DeltaOp(%d)", int(op))
+}
+
+// DeltaCommand describes a single command of a delta. For DeltaCopy, Length
+// bytes are copied from Offset in the basis. For DeltaLiteral, Length bytes of
+// literal data follow in the delta; Offset is unused.
+type DeltaCommand struct {
+	Op     DeltaOp
+	Offset int64
+	Length int64
+}
+
+// ParseDelta reads delta and returns its commands, without applying it. The
+// literal data itself is skipped. This is useful to inspect how a file changed
+// relative to its basis.
+func ParseDelta(delta io.Reader) ([]DeltaCommand, error) {
+	dec := newDeltaDecoder(delta)
+
+	var cmds []DeltaCommand
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			return cmds, nil
+		}
+		if err != nil {
+			return cmds, err
+		}
+
+		c := DeltaCommand{Op: DeltaCopy, Offset: cmd.offset, Length: cmd.length}
+		if cmd.op == opLiteral {
+			c = DeltaCommand{Op: DeltaLiteral, Length: cmd.length}
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+var ErrCopyOutOfRange = errors.New("Delta copies data beyond the end of the basis")
+
+// VerifyDelta checks that delta is well-formed and only copies data from the
+// part of the basis that sig covers, without applying it. The size of the
+// basis is not part of the signature, so the end of its last block is taken as
+// the limit, which can be up to BlockLen-1 bytes beyond the end of the basis.
+// VerifyDeltaSize checks against the actual size. Copies beyond the limit
+// return an error wrapping ErrCopyOutOfRange.
+func VerifyDelta(sig Signature, delta io.Reader) error {
+	if sig.closed() {
+		return errors.New("Can not verify a delta against a closed signature")
+	}
+	return verifyDelta(delta, sig.maxBasisSize())
+}
+
+// VerifyDeltaSize is like VerifyDelta, but copies must stay within basisSize,
+// the size of the basis sig was generated from.
+func VerifyDeltaSize(sig Signature, delta io.Reader, basisSize int64) error {
+	if err := sig.checkBasisSize(basisSize); err != nil {
+		return err
+	}
+	return verifyDelta(delta, basisSize)
+}
+
+func verifyDelta(delta io.Reader, size int64) error {
+	dec := newDeltaDecoder(delta)
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if cmd.op == opCopy && (cmd.offset > size || cmd.length > size-cmd.offset) {
+			return fmt.Errorf("%w: copy of %d bytes at offset %d, basis has %d bytes", ErrCopyOutOfRange, cmd.length, cmd.offset, size)
+		}
+	}
+}
+
+// maxBasisSize returns the end of the last block of the signature, the
+// largest size the basis can have.
+func (s Signature) maxBasisSize() int64 {
+	return int64(s.blocks) * int64(s.header.blockLen)
+}
+
+// checkBasisSize returns an error if the basis sig was generated from can not
+// have size bytes.
+func (s Signature) checkBasisSize(size int64) error {
+	if s.closed() {
+		return ErrSignatureClosed
+	}
+
+	upper := s.maxBasisSize()
+	lower := upper - int64(s.header.blockLen) + 1
+	if s.blocks == 0 {
+		lower = 0
+	}
+	if size < lower || size > upper {
+		return fmt.Errorf("Basis size %d does not match the signature of %d blocks of %d bytes", size, s.blocks, s.header.blockLen)
+	}
+	return nil
+}
+
+// deltaEncoder writes the commands of a delta.
+type deltaEncoder struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func newDeltaEncoder(delta io.Writer) *deltaEncoder {
+	return &deltaEncoder{w: bufio.NewWriter(delta)}
+}
+
+// intWidthIndex returns the index of the smallest integer width of 1, 2, 4 or
+// 8 bytes that holds n.
+func intWidthIndex(n int64) byte {
+	switch {
+	case n < 1<<8:
+		return 0
+	case n < 1<<16:
+		return 1
+	case n < 1<<32:
+		return 2
+	}
+	return 3
+}
+
+func (e *deltaEncoder) writeInt(n int64, widthIndex byte) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	_, err := e.w.Write(buf[8-(1<<widthIndex):])
+	return err
+}
+
+func (e *deltaEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	return e.writeInt(DeltaMagic, 2)
+}
+
+// literal writes a literal command, which has to be followed by length bytes
+// of data written with Write.
+func (e *deltaEncoder) literal(length int64) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+
+	if length <= opLiteralMaxImm {
+		return e.w.WriteByte(byte(length))
+	}
+	i := intWidthIndex(length)
+	if err := e.w.WriteByte(opLiteralN1 + i); err != nil {
+		return err
+	}
+	return e.writeInt(length, i)
+}
+
+func (e *deltaEncoder) Write(p []byte) (int, error) {
+	return e.w.Write(p)
+}
+
+func (e *deltaEncoder) copy(offset, length int64) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+
+	iOffset, iLength := intWidthIndex(offset), intWidthIndex(length)
+	if err := e.w.WriteByte(opCopyN1N1 + iOffset*4 + iLength); err != nil {
+		return err
+	}
+	if err := e.writeInt(offset, iOffset); err != nil {
+		return err
+	}
+	return e.writeInt(length, iLength)
+}
+
+// end writes the end command and flushes the delta.
+func (e *deltaEncoder) end() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	if err := e.w.WriteByte(opEnd); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// PatchAt applies delta to basis like Patch, but writes the output of each
+// command at its offset in out instead of appending to a stream. The delta is
+// decoded in Go, one command after another. out must not share storage with
+// basis, as copies could read data that was already overwritten.
+func PatchAt(basis io.ReaderAt, delta io.Reader, out io.WriterAt) error {
+	dec := newDeltaDecoder(delta)
+
+	var pos int64
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var src io.Reader = dec
+		if cmd.op == opCopy {
+			src = io.NewSectionReader(basis, cmd.offset, cmd.length)
+		}
+		n, err := io.Copy(io.NewOffsetWriter(out, pos), src)
+		if err == nil && n < cmd.length {
+			err = ErrInputEnded
+		}
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+}
+
+// maxInlineLiteral limits the length of literal commands InlineShortCopies
+// merges, which have to be kept in memory. Longer literals are streamed.
+const maxInlineLiteral = 1 << 20
+
+// InlineShortCopies rewrites delta, replacing copy commands shorter than
+// minCopyLen by literals of the data they copy from basis, and merging
+// neighbouring literals. The resulting delta is written to out.
+//
+// This makes the delta larger, but can make it considerably more compressible:
+// Short copies break up the literal data with command bytes and offsets, which
+// compress badly, while the copied data itself often compresses well, e.g. for
+// text. librsync has no minimum match length of its own, so this is done as a
+// separate pass, which needs the basis.
+func InlineShortCopies(delta io.Reader, basis io.ReaderAt, out io.Writer, minCopyLen int64) error {
+	dec := newDeltaDecoder(delta)
+	enc := newDeltaEncoder(out)
+
+	var pending bytes.Buffer
+	flush := func() error {
+		if pending.Len() == 0 {
+			return nil
+		}
+		if err := enc.literal(int64(pending.Len())); err != nil {
+			return err
+		}
+		_, err := pending.WriteTo(enc)
+		return err
+	}
+
+	// add appends length bytes of r to the pending literal. Data that does not
+	// fit below maxInlineLiteral is written as a literal of its own after
+	// what is pending, without buffering it.
+	add := func(r io.Reader, length int64) error {
+		var w io.Writer = &pending
+		if int64(pending.Len())+length > maxInlineLiteral {
+			if err := flush(); err != nil {
+				return err
+			}
+			if length > maxInlineLiteral {
+				if err := enc.literal(length); err != nil {
+					return err
+				}
+				w = enc
+			}
+		}
+
+		_, err := io.CopyN(w, r, length)
+		if err == io.EOF {
+			err = ErrInputEnded
+		}
+		return err
+	}
+
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case cmd.op == opLiteral:
+			err = add(dec, cmd.length)
+		case cmd.length < minCopyLen:
+			err = add(io.NewSectionReader(basis, cmd.offset, cmd.length), cmd.length)
+		default:
+			if err = flush(); err == nil {
+				err = enc.copy(cmd.offset, cmd.length)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	return enc.end()
+}
+
+// deltaHint makes a delta generation job start its delta with a copy of a
+// known prefix, see NewDeltaGenHint. librsync only gets the rest of the new
+// file, and its magic number is dropped in favour of the one in head.
+type deltaHint struct {
+	head   []byte // magic number and copy of the prefix
+	prefix int64
+}
+
+// start sets up the job to skip the prefix of its input and output head
+// first. It is called whenever the job (re)starts.
+func (h *deltaHint) start(job *Job) {
+	job.in = &prefixSkipper{r: job.in, n: h.prefix}
+	job.outbuf = job.outbufTotal[:copy(job.outbufTotal, h.head)]
+	job.inTotal = h.prefix
+	job.outTotal = int64(len(job.outbuf))
+	job.dropOut = 4
+}
+
+// NewDeltaGenHint is like NewDeltaGen, for a new file whose first knownPrefix
+// bytes are identical to the basis, as is common for append-only files. The
+// delta copies the prefix with a single command, and librsync only scans the
+// rest of newfile. The prefix is skipped without checking it, so a wrong hint
+// results in a wrong delta. Reset skips the prefix of the new input as well.
+//
+// The size of the basis is not part of the signature, so knownPrefix is only
+// checked against the end of the last block, which can be up to BlockLen-1
+// bytes beyond the end of the basis. A prefix beyond the end of the basis
+// results in a delta that fails to patch. NewDeltaGenHintSize checks against
+// the actual size. Larger prefixes return an error wrapping ErrCopyOutOfRange.
+func NewDeltaGenHint(sig Signature, newfile io.Reader, knownPrefix int64) (*Job, error) {
+	if sig.closed() {
+		return nil, ErrSignatureClosed
+	}
+	return newDeltaGenHint(sig, newfile, knownPrefix, sig.maxBasisSize())
+}
+
+// NewDeltaGenHintSize is like NewDeltaGenHint, but knownPrefix must not
+// exceed basisSize, the size of the basis sig was generated from.
+func NewDeltaGenHintSize(sig Signature, newfile io.Reader, knownPrefix, basisSize int64) (*Job, error) {
+	if err := sig.checkBasisSize(basisSize); err != nil {
+		return nil, err
+	}
+	return newDeltaGenHint(sig, newfile, knownPrefix, basisSize)
+}
+
+func newDeltaGenHint(sig Signature, newfile io.Reader, knownPrefix, basisSize int64) (*Job, error) {
+	if knownPrefix < 0 {
+		return nil, errors.New("Known prefix must not be negative")
+	}
+	if knownPrefix > basisSize {
+		return nil, fmt.Errorf("%w: known prefix of %d bytes, basis has %d bytes", ErrCopyOutOfRange, knownPrefix, basisSize)
+	}
+	if knownPrefix == 0 {
+		return NewDeltaGen(sig, newfile)
+	}
+
+	head := new(bytes.Buffer)
+	enc := newDeltaEncoder(head)
+	if err := enc.copy(0, knownPrefix); err != nil {
+		return nil, err
+	}
+	if err := enc.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	job, err := NewDeltaGen(sig, newfile)
+	if err != nil {
+		return nil, err
+	}
+	job.hint = &deltaHint{head: head.Bytes(), prefix: knownPrefix}
+	job.hint.start(job)
+	return job, nil
+}
+
+// prefixSkipper discards the first n bytes of r.
+type prefixSkipper struct {
+	r io.Reader
+	n int64
+}
+
+func (s *prefixSkipper) Read(p []byte) (int, error) {
+	if s.n > 0 {
+		m, err := io.CopyN(io.Discard, s.r, s.n)
+		s.n -= m
+		if err == io.EOF {
+			err = errors.New("New file is shorter than the known prefix")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.r.Read(p)
+}
@@ -0,0 +1,453 @@
+package librsync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDeltaDecoder(t *testing.T) {
+	dec := newDeltaDecoder(bytes.NewReader(testdata.Delta()))
+
+	var total int64
+	for {
+		cmd, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("decoding delta failed: %s", err)
+		}
+		total += cmd.length
+	}
+
+	if total != int64(len(testdata.Mutation())) {
+		t.Fatalf("delta commands describe %d bytes, expected %d", total, len(testdata.Mutation()))
+	}
+}
+
+func TestIsNoOpDelta(t *testing.T) {
+	magic := []byte{0x72, 0x73, 0x02, 0x36}
+	tests := []struct {
+		name      string
+		delta     []byte
+		basisSize int64
+		noop      bool
+	}{
+		{"empty", append(magic, opEnd), 0, true},
+		{"empty, non-empty basis", append(magic, opEnd), 8192, false},
+		{"single copy", append(magic, opCopyN1N1+1, 0x00, 0x20, 0x00, opEnd), 8192, true},
+		{"copy of a prefix", append(magic, opCopyN1N1+1, 0x00, 0x20, 0x00, opEnd), 10000, false},
+		{"copy with offset", append(magic, opCopyN1N1, 0x10, 0x20, opEnd), 0x30, false},
+		{"literal", append(magic, 0x02, 'h', 'i', opEnd), 2, false},
+		{"adjacent copies", append(magic, opCopyN1N1, 0x00, 0x20, opCopyN1N1, 0x20, 0x20, opEnd), 0x40, true},
+		{"repeated copies", append(magic, opCopyN1N1, 0x00, 0x20, opCopyN1N1, 0x00, 0x20, opEnd), 0x40, false},
+		{"copy beyond the basis", append(magic, opCopyN1N1, 0x00, 0x20, opEnd), 0x10, false},
+		{"testdata", testdata.Delta(), int64(len(testdata.RandomData())), false},
+		// Split copies are accepted on purpose, the file is still unchanged.
+		{"split copy", append(magic, opCopyN1N1+4, 0x00, 0x00, 0xff, opCopyN1N1+5, 0x00, 0xff, 0x1e, 0x01, opCopyN1N1+5, 0x1f, 0x00, 0x01, 0x00, opEnd), 0x2000, true},
+		{"split copy with a gap", append(magic, opCopyN1N1+4, 0x00, 0x00, 0xff, opCopyN1N1+5, 0x01, 0x00, 0x1f, 0x00, opEnd), 0x2000, false},
+	}
+
+	for _, test := range tests {
+		noop, err := IsNoOpDelta(bytes.NewReader(test.delta), test.basisSize)
+		if err != nil {
+			t.Errorf("%s: IsNoOpDelta failed: %s", test.name, err)
+		} else if noop != test.noop {
+			t.Errorf("%s: expected %t, got %t", test.name, test.noop, noop)
+		}
+	}
+
+	if _, err := IsNoOpDelta(bytes.NewReader(magic), 0); err != ErrInputEnded {
+		t.Errorf("truncated delta: expected %s, got %v", ErrInputEnded, err)
+	}
+}
+
+func TestDeltaOutputSize(t *testing.T) {
+	size, err := deltaOutputSize(bytes.NewReader(testdata.Delta()))
+	if err != nil {
+		t.Fatalf("deltaOutputSize failed: %s", err)
+	}
+	if size != int64(len(testdata.Mutation())) {
+		t.Errorf("got size %d, expected %d", size, len(testdata.Mutation()))
+	}
+}
+
+func TestParseDelta(t *testing.T) {
+	cmds, err := ParseDelta(bytes.NewReader(testdata.Delta()))
+	if err != nil {
+		t.Fatalf("ParseDelta failed: %s", err)
+	}
+
+	expected := []DeltaCommand{
+		{Op: DeltaLiteral, Length: 2054},
+		{Op: DeltaCopy, Offset: 2048, Length: 4096},
+		{Op: DeltaLiteral, Length: 2049},
+	}
+	if fmt.Sprint(cmds) != fmt.Sprint(expected) {
+		t.Errorf("got commands %v, expected %v", cmds, expected)
+	}
+
+	if _, err := ParseDelta(bytes.NewReader(testdata.Delta()[:100])); err != ErrInputEnded {
+		t.Errorf("truncated delta: expected ErrInputEnded, got %v", err)
+	}
+}
+
+func TestVerifyDelta(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	if err := VerifyDelta(sig, bytes.NewReader(testdata.Delta())); err != nil {
+		t.Errorf("VerifyDelta failed for a valid delta: %s", err)
+	}
+
+	magic := []byte{0x72, 0x73, 0x02, 0x36}
+	beyond := append(magic, opCopyN1N1+5, 0x1f, 0x40, 0x01, 0xf4, opEnd) // 500 bytes at 8000
+	if err := VerifyDelta(sig, bytes.NewReader(beyond)); !errors.Is(err, ErrCopyOutOfRange) {
+		t.Errorf("expected ErrCopyOutOfRange, got %v", err)
+	}
+
+	truncated := testdata.Delta()[:10]
+	if err := VerifyDelta(sig, bytes.NewReader(truncated)); err != ErrInputEnded {
+		t.Errorf("expected ErrInputEnded for a truncated delta, got %v", err)
+	}
+
+	// 100 bytes at 8000 lie within the last block, but beyond a basis of
+	// 8050 bytes.
+	overshoot := append(magic, opCopyN1N1+4, 0x1f, 0x40, 0x64, opEnd)
+	if err := VerifyDelta(sig, bytes.NewReader(overshoot)); err != nil {
+		t.Errorf("VerifyDelta failed for a copy within the last block: %s", err)
+	}
+	if err := VerifyDeltaSize(sig, bytes.NewReader(overshoot), 8050); !errors.Is(err, ErrCopyOutOfRange) {
+		t.Errorf("expected ErrCopyOutOfRange beyond the basis size, got %v", err)
+	}
+	if err := VerifyDeltaSize(sig, bytes.NewReader(testdata.Delta()), 8192); err != nil {
+		t.Errorf("VerifyDeltaSize failed for a valid delta: %s", err)
+	}
+	if err := VerifyDeltaSize(sig, bytes.NewReader(testdata.Delta()), 10000); err == nil {
+		t.Errorf("basis size that does not match the signature was accepted")
+	}
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	commands := []deltaCommand{
+		{op: opLiteral, length: 3},
+		{op: opCopy, offset: 0, length: 10},
+		{op: opLiteral, length: 300},
+		{op: opCopy, offset: 1 << 20, length: 70000},
+		{op: opCopy, offset: 1 << 40, length: 1},
+	}
+
+	buf := new(bytes.Buffer)
+	enc := newDeltaEncoder(buf)
+	for _, cmd := range commands {
+		if cmd.op == opCopy {
+			enc.copy(cmd.offset, cmd.length)
+		} else {
+			enc.literal(cmd.length)
+			enc.Write(make([]byte, cmd.length))
+		}
+	}
+	if err := enc.end(); err != nil {
+		t.Fatalf("encoding failed: %s", err)
+	}
+
+	dec := newDeltaDecoder(buf)
+	for i, expected := range commands {
+		cmd, err := dec.next()
+		if err != nil {
+			t.Fatalf("decoding command %d failed: %s", i, err)
+		}
+		if cmd != expected {
+			t.Errorf("command %d: expected %+v, got %+v", i, expected, cmd)
+		}
+	}
+	if _, err := dec.next(); err != io.EOF {
+		t.Errorf("expected the end of the delta, got %v", err)
+	}
+}
+
+// writerAt is an io.WriterAt over a growing byte slice.
+type writerAt struct {
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func TestPatchAt(t *testing.T) {
+	out := new(writerAt)
+	if err := PatchAt(bytes.NewReader(testdata.RandomData()), bytes.NewReader(testdata.Delta()), out); err != nil {
+		t.Fatalf("PatchAt failed: %s", err)
+	}
+	if !bytes.Equal(out.buf, testdata.Mutation()) {
+		t.Errorf("patched data differs from the mutation")
+	}
+
+	err := PatchAt(bytes.NewReader(testdata.RandomData()[:4096]), bytes.NewReader(testdata.Delta()), new(writerAt))
+	if err != ErrInputEnded {
+		t.Errorf("short basis: expected ErrInputEnded, got %v", err)
+	}
+}
+
+func TestInlineShortCopies(t *testing.T) {
+	basis := bytes.NewReader(testdata.RandomData())
+
+	// Without a threshold, the delta stays the same.
+	out := new(bytes.Buffer)
+	if err := InlineShortCopies(bytes.NewReader(testdata.Delta()), basis, out, 0); err != nil {
+		t.Fatalf("InlineShortCopies failed: %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), testdata.Delta()) {
+		t.Errorf("delta changed without a threshold")
+	}
+
+	// The copy of 4096 bytes becomes a literal, merged with its neighbours.
+	out.Reset()
+	if err := InlineShortCopies(bytes.NewReader(testdata.Delta()), basis, out, 5000); err != nil {
+		t.Fatalf("InlineShortCopies failed: %s", err)
+	}
+
+	dec := newDeltaDecoder(bytes.NewReader(out.Bytes()))
+	if cmd, err := dec.next(); err != nil || cmd.op != opLiteral || cmd.length != int64(len(testdata.Mutation())) {
+		t.Errorf("expected a single literal, got %+v, %v", cmd, err)
+	}
+
+	newfile, err := PatchBytes(testdata.RandomData(), out.Bytes())
+	if err != nil {
+		t.Fatalf("PatchBytes failed: %s", err)
+	}
+	if !bytes.Equal(newfile, testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+}
+
+// readHook is an empty reader that calls its function when it is reached.
+type readHook func()
+
+func (h readHook) Read(p []byte) (int, error) {
+	h()
+	return 0, io.EOF
+}
+
+func TestInlineShortCopiesLongLiteral(t *testing.T) {
+	basis := []byte("0123456789")
+	long := bytes.Repeat([]byte("long literal "), 3*maxInlineLiteral/13)
+
+	raw := new(bytes.Buffer)
+	enc := newDeltaEncoder(raw)
+	enc.literal(5)
+	enc.Write([]byte("hello"))
+	enc.copy(0, 10)
+	enc.literal(int64(len(long)))
+	enc.Write(long)
+	enc.literal(4)
+	enc.Write([]byte("tail"))
+	if err := enc.end(); err != nil {
+		t.Fatalf("encoding failed: %s", err)
+	}
+
+	// The long literal is streamed: Most of it is written before the rest of
+	// it was read.
+	out := new(bytes.Buffer)
+	half := raw.Len() / 2
+	delta := io.MultiReader(bytes.NewReader(raw.Bytes()[:half]), readHook(func() {
+		if out.Len() < maxInlineLiteral {
+			t.Errorf("only %d bytes written halfway through a long literal", out.Len())
+		}
+	}), bytes.NewReader(raw.Bytes()[half:]))
+
+	if err := InlineShortCopies(delta, bytes.NewReader(basis), out, 100); err != nil {
+		t.Fatalf("InlineShortCopies failed: %s", err)
+	}
+
+	expected := [][]byte{append([]byte("hello"), basis...), long, []byte("tail")}
+	dec := newDeltaDecoder(out)
+	for i, data := range expected {
+		cmd, err := dec.next()
+		if err != nil {
+			t.Fatalf("decoding command %d failed: %s", i, err)
+		}
+		got, err := io.ReadAll(dec)
+		if err != nil {
+			t.Fatalf("reading literal %d failed: %s", i, err)
+		}
+		if cmd.op != opLiteral || !bytes.Equal(got, data) {
+			t.Errorf("command %d: expected a literal of %d bytes, got %+v", i, len(data), cmd)
+		}
+	}
+	if _, err := dec.next(); err != io.EOF {
+		t.Errorf("expected the end of the delta, got %v", err)
+	}
+}
+
+func TestNewDeltaGenHint(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	appended := append(testdata.RandomData(), "appended data"...)
+
+	deltagen, err := NewDeltaGenHint(sig, bytes.NewReader(appended), 8000)
+	if err != nil {
+		t.Fatalf("NewDeltaGenHint failed: %s", err)
+	}
+	defer deltagen.Close()
+
+	delta, err := io.ReadAll(deltagen)
+	if err != nil {
+		t.Fatalf("delta generation failed: %s", err)
+	}
+
+	cmds, err := ParseDelta(bytes.NewReader(delta))
+	if err != nil {
+		t.Fatalf("ParseDelta failed: %s", err)
+	}
+	if len(cmds) == 0 || cmds[0] != (DeltaCommand{Op: DeltaCopy, Offset: 0, Length: 8000}) {
+		t.Errorf("delta does not start with a copy of the prefix: %v", cmds)
+	}
+
+	newfile, err := PatchBytes(testdata.RandomData(), delta)
+	if err != nil {
+		t.Fatalf("PatchBytes failed: %s", err)
+	}
+	if !bytes.Equal(newfile, appended) {
+		t.Errorf("patch result and new file are not equal")
+	}
+
+	stats, err := deltagen.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %s", err)
+	}
+	if stats.CopyBytes+stats.LitBytes != int64(len(appended)) || stats.InBytes != int64(len(appended)) {
+		t.Errorf("stats do not cover the new file: %+v", stats)
+	}
+	if stats.OutBytes != int64(len(delta)) {
+		t.Errorf("stats report %d output bytes, delta has %d", stats.OutBytes, len(delta))
+	}
+
+	// The prefix is skipped again after a reset.
+	if err := deltagen.Reset(bytes.NewReader(appended)); err != nil {
+		t.Fatalf("Reset failed: %s", err)
+	}
+	again, err := io.ReadAll(deltagen)
+	if err != nil {
+		t.Fatalf("delta generation after Reset failed: %s", err)
+	}
+	if !bytes.Equal(again, delta) {
+		t.Errorf("delta after Reset differs")
+	}
+
+	if _, err := NewDeltaGenHint(sig, bytes.NewReader(appended), 8193); !errors.Is(err, ErrCopyOutOfRange) {
+		t.Errorf("expected ErrCopyOutOfRange for a prefix beyond the signature, got %v", err)
+	}
+
+	// With the size of the basis, a prefix beyond it is caught even within
+	// the last block.
+	if _, err := NewDeltaGenHintSize(sig, bytes.NewReader(appended), 8100, 8000); !errors.Is(err, ErrCopyOutOfRange) {
+		t.Errorf("expected ErrCopyOutOfRange for a prefix beyond the basis size, got %v", err)
+	}
+	if _, err := NewDeltaGenHintSize(sig, bytes.NewReader(appended), 100, 6000); err == nil {
+		t.Errorf("basis size that does not match the signature was accepted")
+	}
+}
+
+func TestNewDeltaGenHintWrong(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	// The mutation differs from the basis in its first bytes, but the hint
+	// claims otherwise. The delta is generated without an error, and copies
+	// the prefix from the basis anyway.
+	basis, newfile := testdata.RandomData(), testdata.Mutation()
+	deltagen, err := NewDeltaGenHint(sig, bytes.NewReader(newfile), 4096)
+	if err != nil {
+		t.Fatalf("NewDeltaGenHint failed: %s", err)
+	}
+	defer deltagen.Close()
+
+	delta, err := io.ReadAll(deltagen)
+	if err != nil {
+		t.Fatalf("delta generation failed: %s", err)
+	}
+	result, err := PatchBytes(basis, delta)
+	if err != nil {
+		t.Fatalf("PatchBytes failed: %s", err)
+	}
+
+	expected := append(basis[:4096:4096], newfile[4096:]...)
+	if !bytes.Equal(result, expected) {
+		t.Errorf("patch result is not the basis prefix followed by the rest of the new file")
+	}
+	if bytes.Equal(result, newfile) {
+		t.Errorf("a wrong hint reproduced the new file")
+	}
+}
+
+// textCorpus generates text with some words changed between versions.
+func textCorpus(seed int64, changes int) (basis, newfile []byte) {
+	words := strings.Fields("the quick brown fox jumps over the lazy dog while a delta of two files holds copies and literals")
+	rng := rand.New(rand.NewSource(seed))
+
+	text := make([]string, 50000)
+	for i := range text {
+		text[i] = words[rng.Intn(len(words))]
+	}
+	basis = []byte(strings.Join(text, " "))
+
+	for i := 0; i < changes; i++ {
+		text[rng.Intn(len(text))] = words[rng.Intn(len(words))]
+	}
+	return basis, []byte(strings.Join(text, " "))
+}
+
+func BenchmarkInlineShortCopies(b *testing.B) {
+	basis, newfile := textCorpus(1, 2000)
+
+	sig, err := SignatureBytes(basis, Config{BlockLen: 64})
+	if err != nil {
+		b.Fatalf("SignatureBytes failed: %s", err)
+	}
+	delta, err := DeltaBytes(sig, newfile)
+	if err != nil {
+		b.Fatalf("DeltaBytes failed: %s", err)
+	}
+
+	for _, minCopyLen := range []int64{0, 256, 1024} {
+		b.Run(fmt.Sprintf("min%d", minCopyLen), func(b *testing.B) {
+			out := new(bytes.Buffer)
+			for i := 0; i < b.N; i++ {
+				out.Reset()
+				if err := InlineShortCopies(bytes.NewReader(delta), bytes.NewReader(basis), out, minCopyLen); err != nil {
+					b.Fatalf("InlineShortCopies failed: %s", err)
+				}
+			}
+
+			compressed := new(bytes.Buffer)
+			zw := gzip.NewWriter(compressed)
+			zw.Write(out.Bytes())
+			zw.Close()
+
+			b.ReportMetric(float64(out.Len()), "delta-bytes")
+			b.ReportMetric(float64(compressed.Len()), "gzip-bytes")
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package librsync
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// FileDigest computes the MD4 digest of everything read from r. Only MD4 is
+// supported, other algorithms return ErrUnsupportedHash: librsync does not
+// export its BLAKE2 implementation, and the standard library has none.
+//
+// The digest is computed in Go. librsync's streaming MD4 functions need an
+// rs_mdfour_t, whose size is private to librsync, so it can not be allocated
+// safely from here.
+func FileDigest(r io.Reader, algo HashAlgorithm) ([]byte, error) {
+	if algo != MD4 {
+		return nil, ErrUnsupportedHash
+	}
+
+	md := newMD4()
+	buf := make([]byte, inbufSize)
+	for {
+		n, err := r.Read(buf)
+		md.write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return md.sum(), nil
+}
+
+// md4 computes an MD4 digest as specified in RFC 1320.
+type md4 struct {
+	s    [4]uint32
+	x    [64]byte // partial block
+	nx   int
+	size uint64
+}
+
+func newMD4() *md4 {
+	return &md4{s: [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}}
+}
+
+func (d *md4) write(p []byte) {
+	d.size += uint64(len(p))
+	if d.nx > 0 {
+		n := copy(d.x[d.nx:], p)
+		d.nx += n
+		p = p[n:]
+		if d.nx < len(d.x) {
+			return
+		}
+		d.block(d.x[:])
+		d.nx = 0
+	}
+	for len(p) >= len(d.x) {
+		d.block(p[:len(d.x)])
+		p = p[len(d.x):]
+	}
+	d.nx = copy(d.x[:], p)
+}
+
+func (d *md4) sum() []byte {
+	// Pad to 56 bytes modulo 64, then append the length in bits.
+	size := d.size
+	var pad [72]byte
+	pad[0] = 0x80
+	n := 56 - int(size%64)
+	if n <= 0 {
+		n += 64
+	}
+	binary.LittleEndian.PutUint64(pad[n:], size*8)
+	d.write(pad[:n+8])
+
+	digest := make([]byte, 16)
+	for i, v := range d.s {
+		binary.LittleEndian.PutUint32(digest[4*i:], v)
+	}
+	return digest
+}
+
+var (
+	md4Shift1 = [4]int{3, 7, 11, 19}
+	md4Shift2 = [4]int{3, 5, 9, 13}
+	md4Shift3 = [4]int{3, 9, 11, 15}
+	md4Order3 = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+)
+
+func (d *md4) block(p []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(p[4*i:])
+	}
+	a, b, c, dd := d.s[0], d.s[1], d.s[2], d.s[3]
+
+	for i := 0; i < 16; i++ {
+		f := b&c | ^b&dd
+		a = bits.RotateLeft32(a+f+x[i], md4Shift1[i%4])
+		a, b, c, dd = dd, a, b, c
+	}
+	for i := 0; i < 16; i++ {
+		g := b&c | b&dd | c&dd
+		a = bits.RotateLeft32(a+g+x[i/4+(i%4)*4]+0x5a827999, md4Shift2[i%4])
+		a, b, c, dd = dd, a, b, c
+	}
+	for i := 0; i < 16; i++ {
+		h := b ^ c ^ dd
+		a = bits.RotateLeft32(a+h+x[md4Order3[i]]+0x6ed9eba1, md4Shift3[i%4])
+		a, b, c, dd = dd, a, b, c
+	}
+
+	d.s[0] += a
+	d.s[1] += b
+	d.s[2] += c
+	d.s[3] += dd
+}
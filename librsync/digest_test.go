@@ -0,0 +1,51 @@
+package librsync
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFileDigest(t *testing.T) {
+	// Test vectors from RFC 1320
+	for input, expected := range map[string]string{
+		"":                           "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":                          "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":                        "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest":             "d9130a8164549fe818874806e1c7014b",
+		"abcdefghijklmnopqrstuvwxyz": "d79e1c308aa5bbcdeea8ed63df412da9",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		digest, err := FileDigest(iotest.OneByteReader(strings.NewReader(input)), MD4)
+		if err != nil {
+			t.Fatalf("FileDigest failed: %s", err)
+		}
+		if got := hex.EncodeToString(digest); got != expected {
+			t.Errorf("MD4(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+
+	if _, err := FileDigest(strings.NewReader(""), BLAKE2); err != ErrUnsupportedHash {
+		t.Errorf("expected ErrUnsupportedHash for BLAKE2, got %v", err)
+	}
+}
+
+func TestFileDigestChunked(t *testing.T) {
+	// Inputs around the block and padding boundaries, read in one piece and
+	// byte by byte.
+	for _, size := range []int{55, 56, 63, 64, 65, 119, 120, 1000} {
+		data := strings.Repeat("x", size)
+		whole, err := FileDigest(strings.NewReader(data), MD4)
+		if err != nil {
+			t.Fatalf("FileDigest failed: %s", err)
+		}
+		bytewise, err := FileDigest(iotest.OneByteReader(strings.NewReader(data)), MD4)
+		if err != nil {
+			t.Fatalf("FileDigest failed: %s", err)
+		}
+		if hex.EncodeToString(whole) != hex.EncodeToString(bytewise) {
+			t.Errorf("%d bytes: digests of whole and bytewise reads differ", size)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package librsync
+
+import (
+	"os"
+)
+
+// syncDir syncs a directory, making renames within it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
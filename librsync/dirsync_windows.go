@@ -0,0 +1,6 @@
+package librsync
+
+// syncDir is a no-op on Windows, where directories can not be synced.
+func syncDir(dir string) error {
+	return nil
+}
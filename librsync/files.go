@@ -0,0 +1,181 @@
+package librsync
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Helpers working on files.
+
+// PatchFileAtomic applies the delta in deltaPath to the basis in basisPath and
+// writes the result to outPath, atomically: The result goes to a temporary
+// file in the directory of outPath, which is synced to disk, renamed to
+// outPath, and then the directory is synced. On failure, the temporary file is
+// removed and outPath is left untouched.
+//
+// If outPath already exists, its permissions are kept. outPath may also be the
+// basis itself: The basis is read through its own file handle, which is closed
+// before the rename.
+func PatchFileAtomic(basisPath, deltaPath, outPath string) error {
+	return PatchFileAtomicConfig(Config{}, basisPath, deltaPath, outPath)
+}
+
+// PatchFileAtomicConfig is like PatchFileAtomic, but takes a Config for the
+// patcher and config.BasisAdvice.
+func PatchFileAtomicConfig(config Config, basisPath, deltaPath, outPath string) error {
+	delta, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+
+	return patchFileAtomic(config, basisPath, delta, outPath)
+}
+
+// PatchFileInPlace applies delta to the file at path and replaces it with the
+// result. The file is not written to while it is read as the basis: The result
+// goes to a temporary file, which is synced and renamed over path, keeping the
+// permissions of path. See PatchFileAtomic.
+func PatchFileInPlace(path string, delta io.Reader) error {
+	return patchFileAtomic(Config{}, path, delta, path)
+}
+
+// SignatureFile is a signature loaded from a file.
+type SignatureFile struct {
+	Signature
+	Path string
+}
+
+// OpenSignatureFile loads the signature in the file at path. The signature is
+// read completely into memory, the file is not kept open.
+func OpenSignatureFile(path string) (*SignatureFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sig, err := LoadSignature(f)
+	if err != nil {
+		return nil, fmt.Errorf("loading signature %s: %w", path, err)
+	}
+	return &SignatureFile{Signature: sig, Path: path}, nil
+}
+
+// Close frees the signature and any resources held for the file.
+func (sf *SignatureFile) Close() error {
+	return sf.Signature.Close()
+}
+
+// CreateDeltaFromFiles creates a delta from the signature in sigPath to the
+// file in newPath and writes it to deltaPath, like rdiff's delta command. On
+// failure, the incomplete delta file is removed.
+func CreateDeltaFromFiles(sigPath, newPath, deltaPath string) (err error) {
+	sig, err := os.Open(sigPath)
+	if err != nil {
+		return err
+	}
+	defer sig.Close()
+
+	newfile, err := os.Open(newPath)
+	if err != nil {
+		return err
+	}
+	defer newfile.Close()
+
+	delta, err := os.Create(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := delta.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(deltaPath)
+		}
+	}()
+
+	return CreateDelta(sig, newfile, delta)
+}
+
+func patchFileAtomic(config Config, basisPath string, delta io.Reader, outPath string) (err error) {
+	basis, err := os.Open(basisPath)
+	if err != nil {
+		return err
+	}
+	defer basis.Close()
+
+	if err = AdviseFile(basis, config.BasisAdvice); err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(outPath)
+	if dir == "" {
+		dir = "."
+	}
+
+	fi, err := os.Stat(outPath)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := createTemp(dir, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if exists {
+		if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+			return
+		}
+	}
+
+	patcher, err := NewPatcherConfig(config, delta, basis)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(tmp, patcher)
+	patcher.Close()
+	if err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	// The basis may be outPath itself, and not every system allows replacing
+	// a file that is still open.
+	basis.Close()
+
+	if err = os.Rename(tmp.Name(), outPath); err != nil {
+		return
+	}
+	return syncDir(dir)
+}
+
+// createTemp creates a new temporary file in dir for replacing the file name.
+// Unlike os.CreateTemp, the permissions of the new file follow the umask.
+func createTemp(dir, name string) (*os.File, error) {
+	for {
+		path := filepath.Join(dir, "."+name+".tmp"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatchFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	basisPath := filepath.Join(dir, "basis")
+	deltaPath := filepath.Join(dir, "delta")
+
+	if err := os.WriteFile(basisPath, testdata.RandomData(), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deltaPath, testdata.Delta(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Patch the basis in place.
+	if err := PatchFileAtomic(basisPath, deltaPath, basisPath); err != nil {
+		t.Fatalf("PatchFileAtomic failed: %s", err)
+	}
+
+	got, err := os.ReadFile(basisPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+
+	fi, err := os.Stat(basisPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("permissions were not kept: %s", fi.Mode())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected only basis and delta in the directory, found %d entries", len(entries))
+	}
+}
+
+func TestPatchFileInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, testdata.RandomData(), 0604); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PatchFileInPlace(path, bytes.NewReader(testdata.Delta())); err != nil {
+		t.Fatalf("PatchFileInPlace failed: %s", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0604 {
+		t.Errorf("permissions were not kept: %s", fi.Mode())
+	}
+
+	// A failed patch leaves the file alone.
+	if err := PatchFileInPlace(path, bytes.NewReader(testdata.Delta()[:100])); err == nil {
+		t.Errorf("truncated delta was applied")
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, testdata.Mutation()) {
+		t.Errorf("failed patch changed the file")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file was left behind")
+	}
+}
+
+func TestCreateDeltaFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	sigPath := filepath.Join(dir, "sig")
+	newPath := filepath.Join(dir, "new")
+	deltaPath := filepath.Join(dir, "delta")
+
+	if err := os.WriteFile(sigPath, testdata.RandomDataSig()[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPath, testdata.Mutation(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateDeltaFromFiles(sigPath, newPath, deltaPath); err != nil {
+		t.Fatalf("CreateDeltaFromFiles failed: %s", err)
+	}
+
+	delta, err := os.ReadFile(deltaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newfile := new(bytes.Buffer)
+	if err := Patch(bytes.NewReader(testdata.RandomData()), bytes.NewReader(delta), newfile); err != nil {
+		t.Fatalf("Patch failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+
+	// A broken signature must not leave a delta behind.
+	os.Remove(deltaPath)
+	if err := os.WriteFile(sigPath, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateDeltaFromFiles(sigPath, newPath, deltaPath); err == nil {
+		t.Fatalf("CreateDeltaFromFiles succeeded with a broken signature")
+	}
+	if _, err := os.Stat(deltaPath); !os.IsNotExist(err) {
+		t.Errorf("incomplete delta file was left behind")
+	}
+}
+
+func TestOpenSignatureFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sig")
+	if err := os.WriteFile(path, testdata.RandomDataSig()[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sf, err := OpenSignatureFile(path)
+	if err != nil {
+		t.Fatalf("OpenSignatureFile failed: %s", err)
+	}
+	if sf.Path != path || sf.BlockLen() != 2048 {
+		t.Errorf("unexpected signature file: path %s, block length %d", sf.Path, sf.BlockLen())
+	}
+
+	deltagen, err := NewDeltaGen(sf.Signature, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("NewDeltaGen failed: %s", err)
+	}
+	if _, err := io.Copy(io.Discard, deltagen); err != nil {
+		t.Errorf("delta generation failed: %s", err)
+	}
+	deltagen.Close()
+
+	if err := sf.Close(); err != nil {
+		t.Errorf("Close failed: %s", err)
+	}
+	if sf.BlockLen() != 0 {
+		t.Errorf("signature is still loaded after Close")
+	}
+
+	if _, err := OpenSignatureFile(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestAdviseFile(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "basis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, advice := range []Advice{AdviceNone, AdviceNormal, AdviceSequential, AdviceRandom} {
+		if err := AdviseFile(f, advice); err != nil {
+			t.Errorf("AdviseFile(%d) failed: %s", advice, err)
+		}
+	}
+}
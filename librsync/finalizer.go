@@ -0,0 +1,97 @@
+package librsync
+
+/*
+#include <librsync.h>
+*/
+import "C"
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// Finalizers are a safety net for jobs and signatures that are never closed.
+// Close disarms the finalizer, so the C memory can not be freed twice: once
+// the finalizer runs, the object is unreachable and can not be closed anymore.
+
+var finalizers atomic.Bool
+
+// leakReport holds a func(what string, stack []byte) that is called by the
+// finalizers with the stack that created the unclosed object. It is set by
+// the leakcheck build tag.
+var leakReport atomic.Value
+
+// EnableFinalizers makes jobs and signatures created from now on free their C
+// memory if they are garbage collected without being closed. The inputs of a
+// job are not closed by the finalizer, even with Config.CloseInputs.
+//
+// This is off by default, as finalizers delay the collection of memory and
+// only hide missing calls to Close. Build with the leakcheck tag to enable
+// them and log every job and signature that was not closed, e.g. in tests.
+//
+// Patchers are registered globally for the patch callback until they are
+// closed, so they are never garbage collected and not covered.
+func EnableFinalizers(enable bool) {
+	finalizers.Store(enable)
+}
+
+func reportLeak(what string, stack []byte) {
+	if report, ok := leakReport.Load().(func(string, []byte)); ok && report != nil {
+		report(what, stack)
+	}
+}
+
+// allocStack returns the current stack if leaks are reported.
+func allocStack() []byte {
+	if report, ok := leakReport.Load().(func(string, []byte)); !ok || report == nil {
+		return nil
+	}
+	return debug.Stack()
+}
+
+func (job *Job) track() {
+	if !finalizers.Load() {
+		return
+	}
+	job.tracked = true
+	job.stack = allocStack()
+	runtime.SetFinalizer(job, (*Job).finalize)
+}
+
+func (job *Job) untrack() {
+	if job.tracked {
+		runtime.SetFinalizer(job, nil)
+		job.tracked = false
+	}
+}
+
+func (job *Job) finalize() {
+	reportLeak("Job", job.stack)
+	job.closers = nil
+	job.Close()
+}
+
+func (s *signature) track() {
+	if !finalizers.Load() {
+		return
+	}
+	s.tracked = true
+	s.stack = allocStack()
+	runtime.SetFinalizer(s, (*signature).finalize)
+}
+
+func (s *signature) untrack() {
+	if s.tracked {
+		runtime.SetFinalizer(s, nil)
+		s.tracked = false
+	}
+}
+
+func (s *signature) finalize() {
+	reportLeak("Signature", s.stack)
+	if s.sig != nil {
+		C.rs_free_sumset(s.sig)
+		s.sig = nil
+	}
+}
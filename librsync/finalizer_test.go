@@ -0,0 +1,67 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFinalizers(t *testing.T) {
+	EnableFinalizers(true)
+	defer EnableFinalizers(false)
+
+	oldReport := leakReport.Load()
+	reported := make(chan string, 10)
+	leakReport.Store(func(what string, stack []byte) {
+		reported <- what
+	})
+	defer func() {
+		if oldReport != nil {
+			leakReport.Store(oldReport)
+		} else {
+			leakReport.Store((func(string, []byte))(nil))
+		}
+	}()
+
+	closed, err := NewSignatureGen(Config{}, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	closed.Close()
+
+	func() {
+		if _, err := NewSignatureGen(Config{}, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("NewSignatureGen failed: %s", err)
+		}
+		if _, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0])); err != nil {
+			t.Fatalf("LoadSignature failed: %s", err)
+		}
+	}()
+
+	leaks := map[string]int{}
+	deadline := time.After(5 * time.Second)
+	for leaks["Job"] < 1 || leaks["Signature"] < 1 {
+		runtime.GC()
+		select {
+		case what := <-reported:
+			leaks[what]++
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("unclosed objects were not finalized, got reports %v", leaks)
+		}
+	}
+
+	// The closed job must not be reported.
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case what := <-reported:
+		leaks[what]++
+	default:
+	}
+	if leaks["Job"] != 1 || leaks["Signature"] != 1 {
+		t.Errorf("expected one unclosed job and signature, got reports %v", leaks)
+	}
+}
@@ -0,0 +1,58 @@
+package librsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Format is the kind of a librsync file, see DetectFormat.
+type Format int
+
+const (
+	FormatUnknown Format = iota // not a librsync file
+	FormatSignatureMD4
+	FormatSignatureBLAKE2
+	FormatSignatureRabinKarpMD4
+	FormatSignatureRabinKarpBLAKE2
+	FormatDelta
+)
+
+// IsSignature reports whether the format is one of the signature formats.
+func (f Format) IsSignature() bool {
+	return f >= FormatSignatureMD4 && f <= FormatSignatureRabinKarpBLAKE2
+}
+
+// DetectFormat determines the kind of data in r by its magic number. It
+// returns a reader that yields all of r's data, including the bytes read for
+// detection.
+//
+// Data shorter than a magic number is FormatUnknown. Any data can start with
+// a magic number by chance, so this is not a validation of the data.
+func DetectFormat(r io.Reader) (Format, io.Reader, error) {
+	var buf [4]byte
+	n, err := io.ReadFull(r, buf[:])
+	rest := io.MultiReader(bytes.NewReader(buf[:n]), r)
+
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		return FormatUnknown, rest, nil
+	default:
+		return FormatUnknown, rest, err
+	}
+
+	switch binary.BigEndian.Uint32(buf[:]) {
+	case MD4SigMagic:
+		return FormatSignatureMD4, rest, nil
+	case BLAKE2SigMagic:
+		return FormatSignatureBLAKE2, rest, nil
+	case RabinKarpMD4SigMagic:
+		return FormatSignatureRabinKarpMD4, rest, nil
+	case RabinKarpBLAKE2SigMagic:
+		return FormatSignatureRabinKarpBLAKE2, rest, nil
+	case DeltaMagic:
+		return FormatDelta, rest, nil
+	}
+	return FormatUnknown, rest, nil
+}
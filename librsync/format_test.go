@@ -0,0 +1,39 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for _, tc := range []struct {
+		data   []byte
+		format Format
+	}{
+		{testdata.RandomDataSig()[0], FormatSignatureMD4},
+		{testdata.RandomDataSig()[1], FormatSignatureBLAKE2},
+		{testdata.Delta(), FormatDelta},
+		{testdata.RandomData(), FormatUnknown},
+		{[]byte{0x72, 0x73}, FormatUnknown},
+		{nil, FormatUnknown},
+	} {
+		format, r, err := DetectFormat(iotest.OneByteReader(bytes.NewReader(tc.data)))
+		if err != nil {
+			t.Fatalf("DetectFormat failed: %s", err)
+		}
+		if format != tc.format {
+			t.Errorf("expected format %d, got %d", tc.format, format)
+		}
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading the returned reader failed: %s", err)
+		}
+		if !bytes.Equal(data, tc.data) {
+			t.Errorf("returned reader does not yield the original data")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package librsync
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Helpers for signatures and deltas stored gzip compressed.
+
+// GzipLevel is the compression level of the gzip helpers, so all files they
+// write are compressed the same way.
+const GzipLevel = gzip.DefaultCompression
+
+// gzipTo runs write on a gzip writer on out, which is finished afterwards.
+func gzipTo(out io.Writer, write func(w io.Writer) error) error {
+	gz, err := gzip.NewWriterLevel(out, GzipLevel)
+	if err != nil {
+		return err
+	}
+	if err := write(gz); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// CreateSignatureGz is like CreateSignature, but writes the signature gzip
+// compressed.
+func CreateSignatureGz(basis io.Reader, out io.Writer) error {
+	return gzipTo(out, func(w io.Writer) error {
+		return CreateSignature(basis, w)
+	})
+}
+
+// LoadSignatureGz is like LoadSignature, for a gzip compressed signature.
+func LoadSignatureGz(input io.Reader) (Signature, error) {
+	gz, err := gzip.NewReader(input)
+	if err != nil {
+		return Signature{}, err
+	}
+	defer gz.Close()
+
+	return LoadSignature(gz)
+}
+
+// CreateDeltaGz is like CreateDelta, but reads a gzip compressed signature and
+// writes the delta gzip compressed.
+func CreateDeltaGz(signature, newfile io.Reader, delta io.Writer) error {
+	gz, err := gzip.NewReader(signature)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return gzipTo(delta, func(w io.Writer) error {
+		return CreateDelta(gz, newfile, w)
+	})
+}
+
+// PatchGz is like Patch, for a gzip compressed delta.
+func PatchGz(basis io.ReaderAt, delta io.Reader, newfile io.Writer) error {
+	gz, err := gzip.NewReader(delta)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return Patch(basis, gz, newfile)
+}
@@ -0,0 +1,40 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"testing"
+)
+
+func TestGzipHelpers(t *testing.T) {
+	sig := new(bytes.Buffer)
+	if err := CreateSignatureGz(bytes.NewReader(testdata.RandomData()), sig); err != nil {
+		t.Fatalf("CreateSignatureGz failed: %s", err)
+	}
+	if f, _, _ := DetectFormat(bytes.NewReader(sig.Bytes())); f != FormatUnknown {
+		t.Errorf("signature is not compressed")
+	}
+
+	loaded, err := LoadSignatureGz(bytes.NewReader(sig.Bytes()))
+	if err != nil {
+		t.Fatalf("LoadSignatureGz failed: %s", err)
+	}
+	loaded.Close()
+
+	delta := new(bytes.Buffer)
+	if err := CreateDeltaGz(bytes.NewReader(sig.Bytes()), bytes.NewReader(testdata.Mutation()), delta); err != nil {
+		t.Fatalf("CreateDeltaGz failed: %s", err)
+	}
+
+	newfile := new(bytes.Buffer)
+	if err := PatchGz(bytes.NewReader(testdata.RandomData()), delta, newfile); err != nil {
+		t.Fatalf("PatchGz failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+
+	if err := PatchGz(bytes.NewReader(testdata.RandomData()), bytes.NewReader(testdata.Delta()), new(bytes.Buffer)); err == nil {
+		t.Errorf("PatchGz accepted an uncompressed delta")
+	}
+}
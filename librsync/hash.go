@@ -0,0 +1,182 @@
+package librsync
+
+/*
+#include <stdio.h>
+#include <librsync.h>
+
+#ifndef RS_DEFAULT_STRONG_LEN
+// librsync >= 1.0.0
+#define HAVE_BLAKE2 1
+#else
+#define HAVE_BLAKE2 0
+#endif
+
+// rs_sig_args only exists in librsync >= 2.2.0. Declaring it weak resolves it
+// to NULL with older versions.
+extern rs_result rs_sig_args(rs_long_t old_fsize, rs_magic_number *magic, size_t *block_len, size_t *strong_len) __attribute__((weak));
+
+static inline rs_result sig_args(rs_long_t old_fsize, unsigned int magic, size_t *block_len, size_t *strong_len) {
+	rs_magic_number m = (rs_magic_number) magic;
+	if (rs_sig_args == NULL) {
+		return RS_UNIMPLEMENTED;
+	}
+	return rs_sig_args(old_fsize, &m, block_len, strong_len);
+}
+
+static inline int have_sig_args() {
+	return rs_sig_args != NULL;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HashAlgorithm selects the checksums used by a signature.
+type HashAlgorithm int
+
+const (
+	// DefaultHash is BLAKE2, or MD4 with librsync < 1.0.0 or if
+	// Config.CompatMD4 is set.
+	DefaultHash HashAlgorithm = iota
+
+	MD4             // MD4 strong sums, classic rolling checksum
+	BLAKE2          // BLAKE2 strong sums, classic rolling checksum (librsync >= 1.0.0)
+	RabinKarpMD4    // MD4 strong sums, RabinKarp rolling checksum (librsync >= 2.2.0)
+	RabinKarpBLAKE2 // BLAKE2 strong sums, RabinKarp rolling checksum (librsync >= 2.2.0)
+)
+
+// RollsumType is the rolling checksum used to find matching blocks.
+type RollsumType int
+
+const (
+	UnknownRollsum RollsumType = iota // closed signature or unknown algorithm
+	Rollsum                           // classic rsync rolling checksum
+	RabinKarp                         // RabinKarp rolling checksum (librsync >= 2.2.0)
+)
+
+// rollsum returns the rolling checksum used with the algorithm.
+func (h HashAlgorithm) rollsum() RollsumType {
+	switch h {
+	case MD4, BLAKE2:
+		return Rollsum
+	case RabinKarpMD4, RabinKarpBLAKE2:
+		return RabinKarp
+	}
+	return UnknownRollsum
+}
+
+// maxStrongLen returns the digest size of the strong sums of the algorithm,
+// the longest strong sum length possible.
+func (h HashAlgorithm) maxStrongLen() uint {
+	switch h {
+	case MD4, RabinKarpMD4:
+		return 16
+	}
+	return FullStrongLen
+}
+
+// Signature magic numbers, see Config.Magic
+const (
+	MD4SigMagic             = 0x72730136
+	BLAKE2SigMagic          = 0x72730137
+	RabinKarpMD4SigMagic    = 0x72730146
+	RabinKarpBLAKE2SigMagic = 0x72730147
+)
+
+var (
+	ErrUnsupportedHash = errors.New("Hash algorithm not supported by the linked librsync")
+	ErrUnsupported     = errors.New("Not supported by the linked librsync")
+)
+
+const haveBLAKE2 = C.HAVE_BLAKE2 != 0
+
+func haveSigArgs() bool {
+	return C.have_sig_args() != 0
+}
+
+// magic returns the signature magic number of the algorithm, or
+// ErrUnsupportedHash if the linked librsync does not support it.
+func (h HashAlgorithm) magic() (uint32, error) {
+	switch h {
+	case MD4:
+		return MD4SigMagic, nil
+	case BLAKE2:
+		if haveBLAKE2 {
+			return BLAKE2SigMagic, nil
+		}
+	case RabinKarpMD4, RabinKarpBLAKE2:
+		if !libVersionAtLeast(2, 2) {
+			break
+		}
+		if h == RabinKarpMD4 {
+			return RabinKarpMD4SigMagic, nil
+		}
+		return RabinKarpBLAKE2SigMagic, nil
+	default:
+		return 0, fmt.Errorf("Unknown hash algorithm %d", h)
+	}
+	return 0, ErrUnsupportedHash
+}
+
+// hashByMagic returns the hash algorithm of a signature magic number, or
+// DefaultHash for unknown ones.
+func hashByMagic(magic uint32) HashAlgorithm {
+	switch magic {
+	case MD4SigMagic:
+		return MD4
+	case BLAKE2SigMagic:
+		return BLAKE2
+	case RabinKarpMD4SigMagic:
+		return RabinKarpMD4
+	case RabinKarpBLAKE2SigMagic:
+		return RabinKarpBLAKE2
+	}
+	return DefaultHash
+}
+
+// resolve turns DefaultHash into the algorithm it stands for.
+func (h HashAlgorithm) resolve(compatMD4 bool) HashAlgorithm {
+	if h != DefaultHash {
+		return h
+	}
+	if compatMD4 || !haveBLAKE2 {
+		return MD4
+	}
+	return BLAKE2
+}
+
+// PredictSignatureArgs returns the block length and strong sum length librsync
+// recommends for a signature of a file of the given size, and the size of the
+// resulting signature. With DefaultHash, librsync chooses the algorithm. A
+// negative fileSize stands for an unknown size, sigSize is -1 then.
+//
+// This needs librsync >= 2.2.0, ErrUnsupported is returned otherwise.
+func PredictSignatureArgs(fileSize int64, hash HashAlgorithm) (blockLen, strongLen uint, sigSize int64, err error) {
+	var magic uint32
+	if hash != DefaultHash {
+		if magic, err = hash.magic(); err != nil {
+			return
+		}
+	}
+
+	var cBlockLen, cStrongLen C.size_t
+	switch res := C.sig_args(C.rs_long_t(fileSize), C.uint(magic), &cBlockLen, &cStrongLen); res {
+	case C.RS_DONE:
+	case C.RS_UNIMPLEMENTED:
+		return 0, 0, 0, ErrUnsupported
+	default:
+		return 0, 0, 0, &RsError{Code: int(res), Op: "rs_sig_args"}
+	}
+
+	blockLen = uint(cBlockLen)
+	strongLen = uint(cStrongLen)
+	if fileSize < 0 {
+		return blockLen, strongLen, -1, nil
+	}
+	blocks := (fileSize + int64(blockLen) - 1) / int64(blockLen)
+	sigSize = sigHeaderLen + blocks*(4+int64(strongLen))
+	return
+}
@@ -0,0 +1,51 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"testing"
+)
+
+func TestPredictSignatureArgs(t *testing.T) {
+	blockLen, strongLen, sigSize, err := PredictSignatureArgs(1<<30, MD4)
+	if err == ErrUnsupported {
+		t.Skip("librsync does not support rs_sig_args")
+	}
+	if err != nil {
+		t.Fatalf("PredictSignatureArgs failed: %s", err)
+	}
+
+	if blockLen == 0 || strongLen == 0 || strongLen > 16 {
+		t.Fatalf("implausible parameters: block length %d, strong length %d", blockLen, strongLen)
+	}
+	blocks := (1<<30 + int64(blockLen) - 1) / int64(blockLen)
+	if expected := 12 + blocks*(4+int64(strongLen)); sigSize != expected {
+		t.Errorf("expected signature size %d, got %d", expected, sigSize)
+	}
+}
+
+func TestRollingChecksum(t *testing.T) {
+	for h, expected := range map[HashAlgorithm]RollsumType{
+		DefaultHash:     UnknownRollsum,
+		MD4:             Rollsum,
+		BLAKE2:          Rollsum,
+		RabinKarpMD4:    RabinKarp,
+		RabinKarpBLAKE2: RabinKarp,
+	} {
+		if got := h.rollsum(); got != expected {
+			t.Errorf("hash %d: expected rolling checksum %d, got %d", h, expected, got)
+		}
+	}
+
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	if got := sig.RollingChecksum(); got != Rollsum {
+		t.Errorf("expected Rollsum, got %d", got)
+	}
+	sig.Close()
+	if got := sig.RollingChecksum(); got != UnknownRollsum {
+		t.Errorf("closed signature: expected UnknownRollsum, got %d", got)
+	}
+}
@@ -1,7 +1,11 @@
 package librsync
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 )
 
 // Some helper functions to make things more convenient.
@@ -18,22 +22,72 @@ func CreateSignature(basis io.Reader, signature io.Writer) error {
 	return err
 }
 
+// CreateSignatureMulti writes the signature of the concatenation of the basis
+// readers to out, which is the same as the signature of a single file holding
+// their data. A short final block of one reader is not padded or ended early,
+// the block continues with the data of the next one.
+func CreateSignatureMulti(config Config, out io.Writer, basis ...io.Reader) error {
+	// io.MultiReader hides the sizes TargetBlockCount needs, so sum them up.
+	if config.TargetBlockCount > 0 && config.BasisSize == 0 {
+		for _, r := range basis {
+			size, ok := readerSize(r)
+			if !ok {
+				config.BasisSize = 0
+				break
+			}
+			config.BasisSize += size
+		}
+	}
+
+	siggen, err := NewSignatureGen(config, io.MultiReader(basis...))
+	if err != nil {
+		return err
+	}
+	defer siggen.Close()
+
+	_, err = io.Copy(out, siggen)
+	return err
+}
+
 // CreateDelta wraps around a delta generation job and copies the result to the delta writer.
 func CreateDelta(signature, newfile io.Reader, delta io.Writer) error {
-	sig, err := LoadSignature(signature)
+	_, err := CreateDeltaSizes(signature, newfile, delta)
+	return err
+}
+
+// DeltaSizes are the byte counts of a delta generation, as reported by
+// CreateDeltaSizes.
+type DeltaSizes struct {
+	Signature int64 // signature bytes read
+	NewFile   int64 // new file bytes read
+	Delta     int64 // delta bytes written
+}
+
+// CreateDeltaSizes is like CreateDelta, but also returns how many bytes were
+// read and written, e.g. to compare the size of the delta to the new file. On
+// error, the sizes up to the failure are returned.
+func CreateDeltaSizes(signature, newfile io.Reader, delta io.Writer) (DeltaSizes, error) {
+	sigIn := &CountingReader{R: signature}
+	newIn := &CountingReader{R: newfile}
+	deltaOut := &CountingWriter{W: delta}
+	sizes := func() DeltaSizes {
+		return DeltaSizes{Signature: sigIn.N, NewFile: newIn.N, Delta: deltaOut.N}
+	}
+
+	sig, err := LoadSignature(sigIn)
 	if err != nil {
-		return err
+		return sizes(), err
 	}
 	defer sig.Close()
 
-	deltagen, err := NewDeltaGen(sig, newfile)
+	deltagen, err := NewDeltaGen(sig, newIn)
 	if err != nil {
-		return err
+		return sizes(), err
 	}
 	defer deltagen.Close()
 
-	_, err = io.Copy(delta, deltagen)
-	return err
+	_, err = io.Copy(deltaOut, deltagen)
+	return sizes(), err
 }
 
 // InstantDelta creates a delta file without the extra step of creating a signature.
@@ -71,3 +125,278 @@ func Patch(basis io.ReaderAt, delta io.Reader, newfile io.Writer) error {
 	_, err = io.Copy(newfile, patcher)
 	return err
 }
+
+// PatchAndClose is like Patch, but closes basis, delta and newfile, if they
+// implement io.Closer, once patching is done or failed. An error closing
+// newfile is returned, as it may mean the written data is incomplete.
+func PatchAndClose(basis io.ReaderAt, delta io.Reader, newfile io.Writer) (err error) {
+	defer func() {
+		if closer, ok := newfile.(io.Closer); ok {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	patcher, err := NewPatcherConfig(Config{CloseInputs: true}, delta, basis)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(newfile, patcher); err != nil {
+		patcher.Close()
+		return err
+	}
+	return patcher.Close()
+}
+
+// BestDelta generates deltas of newfile against each of the signatures and
+// writes the smallest one to delta. It returns the index of the chosen
+// signature and the statistics of the written delta.
+//
+// This costs one delta generation pass over newfile per signature, plus one
+// more to write the winning delta (skipped if there is only one signature).
+// newfile therefore has to be seekable; it is rewound to its initial position
+// before every pass.
+func BestDelta(sigs []Signature, newfile io.ReadSeeker, delta io.Writer) (chosenIndex int, stats Stats, err error) {
+	if len(sigs) == 0 {
+		return -1, Stats{}, errors.New("No signatures given")
+	}
+
+	start, err := newfile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1, Stats{}, err
+	}
+
+	if len(sigs) > 1 {
+		var best int64 = -1
+		for i, sig := range sigs {
+			if _, err = newfile.Seek(start, io.SeekStart); err != nil {
+				return -1, Stats{}, err
+			}
+
+			counter := &CountingWriter{}
+			if _, err = writeDelta(sig, newfile, counter); err != nil {
+				return -1, Stats{}, err
+			}
+
+			if best < 0 || counter.N < best {
+				best = counter.N
+				chosenIndex = i
+			}
+		}
+
+		if _, err = newfile.Seek(start, io.SeekStart); err != nil {
+			return -1, Stats{}, err
+		}
+	}
+
+	stats, err = writeDelta(sigs[chosenIndex], newfile, delta)
+	return
+}
+
+// writeDelta generates a delta and returns the statistics of the job.
+func writeDelta(sig Signature, newfile io.Reader, delta io.Writer) (Stats, error) {
+	deltagen, err := NewDeltaGen(sig, newfile)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer deltagen.Close()
+
+	if _, err = io.Copy(delta, deltagen); err != nil {
+		return Stats{}, err
+	}
+	return deltagen.stats(), nil
+}
+
+// DeltaSize returns the size of the delta from sig to newfile, without keeping
+// the delta, e.g. to decide whether sending the delta is worth it. The delta
+// is generated and discarded, so this costs as much time as generating it.
+func DeltaSize(sig Signature, newfile io.Reader) (int64, error) {
+	counter := &CountingWriter{}
+	if _, err := writeDelta(sig, newfile, counter); err != nil {
+		return 0, err
+	}
+	return counter.N, nil
+}
+
+// InstantDeltaBounded is like InstantDelta, but keeps the memory used for the
+// intermediate signature near maxMem. config configures the signature.
+//
+// Half of maxMem is granted to buffering the generated signature. If it grows
+// larger than that, it is moved to a temporary file, which is removed before
+// returning. The other half is left to the loaded signature and its hash
+// table, which librsync always keeps in memory and which take roughly as much
+// space as the serialized signature. All other buffers are small and of fixed
+// size.
+func InstantDeltaBounded(basis, newfile io.Reader, delta io.Writer, maxMem int64, config Config) error {
+	sigbuf := newSpillBuffer(maxMem / 2)
+	defer sigbuf.Close()
+
+	siggen, err := NewSignatureGen(config, basis)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(sigbuf, siggen)
+	siggen.Close()
+	if err != nil {
+		return err
+	}
+
+	sig, err := LoadSignature(sigbuf.reader())
+	if err != nil {
+		return err
+	}
+	defer sig.Close()
+
+	_, err = writeDelta(sig, newfile, delta)
+	return err
+}
+
+// ComposeDeltas turns the delta from basis to v1 (deltaA) and the delta from
+// v1 to v2 (deltaB) into a single delta from basis to v2, which is usually
+// smaller than both deltas together.
+//
+// v1 and v2 are materialized by patching, in memory up to
+// config.SpillThreshold and in temporary files beyond that, which are removed
+// before returning. The result is exactly the delta that comparing v2 with
+// basis directly would give, using a signature of basis generated with config.
+func ComposeDeltas(basis io.ReaderAt, deltaA, deltaB io.Reader, delta io.Writer, config Config) error {
+	v1 := newSpillBuffer(config.spillThreshold())
+	defer v1.Close()
+
+	if err := Patch(basis, deltaA, v1); err != nil {
+		return err
+	}
+
+	v2 := newSpillBuffer(config.spillThreshold())
+	defer v2.Close()
+
+	if err := Patch(v1.reader(), deltaB, v2); err != nil {
+		return err
+	}
+	if err := v1.Close(); err != nil {
+		return err
+	}
+
+	siggen, err := NewSignatureGen(config, io.NewSectionReader(basis, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	defer siggen.Close()
+
+	sig, err := LoadSignature(siggen)
+	if err != nil {
+		return err
+	}
+	defer sig.Close()
+
+	_, err = writeDelta(sig, v2.reader(), delta)
+	return err
+}
+
+// PatchChain applies several deltas one after another: the first one to basis,
+// each following one to the result of the previous one. Only the result of the
+// last delta is written to newfile.
+//
+// The intermediate versions are kept in memory up to config.SpillThreshold
+// and in temporary files beyond that, at most two at a time. The temporary
+// files are removed before returning.
+func PatchChain(basis io.ReaderAt, newfile io.Writer, config Config, deltas ...io.Reader) error {
+	if len(deltas) == 0 {
+		return errors.New("No deltas given")
+	}
+
+	var prev *spillBuffer
+	for i, delta := range deltas[:len(deltas)-1] {
+		version := newSpillBuffer(config.spillThreshold())
+		defer version.Close()
+
+		if err := Patch(basis, delta, version); err != nil {
+			return fmt.Errorf("applying delta %d: %w", i, err)
+		}
+
+		// The previous version is not needed anymore.
+		if prev != nil {
+			if err := prev.Close(); err != nil {
+				return err
+			}
+		}
+		prev = version
+		basis = version.reader()
+	}
+
+	if err := Patch(basis, deltas[len(deltas)-1], newfile); err != nil {
+		return fmt.Errorf("applying delta %d: %w", len(deltas)-1, err)
+	}
+	return nil
+}
+
+// SignatureBytes creates the signature of data in memory.
+func SignatureBytes(data []byte, config Config) ([]byte, error) {
+	siggen, err := NewSignatureGen(config, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer siggen.Close()
+
+	signature := new(bytes.Buffer)
+	if _, err := io.Copy(signature, siggen); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// CreateSignatureInto writes the signature of basis to dst and returns its
+// length. If dst is too small, io.ErrShortBuffer is returned. The size of the
+// signature can be determined in advance with PredictSignatureArgs.
+func CreateSignatureInto(basis io.Reader, config Config, dst []byte) (int, error) {
+	siggen, err := NewSignatureGen(config, basis)
+	if err != nil {
+		return 0, err
+	}
+	defer siggen.Close()
+
+	n, err := io.ReadFull(siggen, dst)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return n, nil
+	case nil:
+	default:
+		return n, err
+	}
+
+	// dst is full, check that there is nothing more.
+	var probe [1]byte
+	for {
+		m, err := siggen.Read(probe[:])
+		if m > 0 {
+			return n, io.ErrShortBuffer
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// DeltaBytes creates the delta from the serialized signature sig to newfile in
+// memory.
+func DeltaBytes(sig, newfile []byte) ([]byte, error) {
+	delta := new(bytes.Buffer)
+	if err := CreateDelta(bytes.NewReader(sig), bytes.NewReader(newfile), delta); err != nil {
+		return nil, err
+	}
+	return delta.Bytes(), nil
+}
+
+// PatchBytes applies delta to basis in memory.
+func PatchBytes(basis, delta []byte) ([]byte, error) {
+	newfile := new(bytes.Buffer)
+	if err := Patch(bytes.NewReader(basis), bytes.NewReader(delta), newfile); err != nil {
+		return nil, err
+	}
+	return newfile.Bytes(), nil
+}
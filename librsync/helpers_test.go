@@ -2,7 +2,12 @@ package librsync
 
 import (
 	"bytes"
+	"errors"
 	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -36,3 +41,441 @@ func TestHelpers(t *testing.T) {
 		}
 	}
 }
+
+func TestBestDelta(t *testing.T) {
+	var sigs []Signature
+	for _, basis := range [][]byte{testdata.RandomData(), testdata.Mutation()} {
+		sigbuf := new(bytes.Buffer)
+		if err := CreateSignature(bytes.NewReader(basis), sigbuf); err != nil {
+			t.Fatalf("CreateSignature failed: %s", err)
+		}
+
+		sig, err := LoadSignature(sigbuf)
+		if err != nil {
+			t.Fatalf("LoadSignature failed: %s", err)
+		}
+		defer sig.Close()
+
+		sigs = append(sigs, sig)
+	}
+
+	delta := new(bytes.Buffer)
+	chosen, stats, err := BestDelta(sigs, bytes.NewReader(testdata.Mutation()), delta)
+	if err != nil {
+		t.Fatalf("BestDelta failed: %s", err)
+	}
+
+	if chosen != 1 {
+		t.Errorf("expected the identical basis (1) to be chosen, got %d", chosen)
+	}
+	if stats.LitBytes != 0 {
+		t.Errorf("expected no literal bytes against the identical basis, got %d", stats.LitBytes)
+	}
+
+	newfile := new(bytes.Buffer)
+	if err := Patch(bytes.NewReader(testdata.Mutation()), delta, newfile); err != nil {
+		t.Fatalf("Patch failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Fatalf("patch result and mutation are not equal")
+	}
+}
+
+func TestComposeDeltas(t *testing.T) {
+	basis := testdata.RandomData()
+	mutation := testdata.Mutation()
+
+	// v1 is the mutation, v2 is the basis again.
+	deltaB := new(bytes.Buffer)
+	if err := InstantDelta(bytes.NewReader(mutation), bytes.NewReader(basis), deltaB); err != nil {
+		t.Fatalf("InstantDelta failed: %s", err)
+	}
+
+	composed := new(bytes.Buffer)
+	err := ComposeDeltas(bytes.NewReader(basis), bytes.NewReader(testdata.Delta()), deltaB, composed, Config{SpillThreshold: 1024})
+	if err != nil {
+		t.Fatalf("ComposeDeltas failed: %s", err)
+	}
+
+	noop, err := IsNoOpDelta(composed, int64(len(basis)))
+	if err != nil {
+		t.Fatalf("IsNoOpDelta failed: %s", err)
+	}
+	if !noop {
+		t.Fatalf("composing a delta with its inverse did not give a no-op delta")
+	}
+}
+
+func TestJobStats(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("Loading signature failed: %s", err)
+	}
+	defer sig.Close()
+
+	deltagen, err := NewDeltaGen(sig, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("could not create a delta generator: %s", err)
+	}
+	defer deltagen.Close()
+
+	if _, err := deltagen.Stats(); err != ErrJobRunning {
+		t.Errorf("expected %s before the job finished, got %v", ErrJobRunning, err)
+	}
+
+	n, err := io.Copy(io.Discard, deltagen)
+	if err != nil {
+		t.Fatalf("Creating the delta failed: %s", err)
+	}
+
+	stats, err := deltagen.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %s", err)
+	}
+	if stats.LitBytes+stats.CopyBytes != int64(len(testdata.Mutation())) {
+		t.Errorf("literal and copied bytes do not add up to the new file: %+v", stats)
+	}
+	if stats.OutBytes != n {
+		t.Errorf("expected %d output bytes, got %d", n, stats.OutBytes)
+	}
+}
+
+// byteReaderAt returns at most one byte per ReadAt call.
+type byteReaderAt struct {
+	r io.ReaderAt
+}
+
+func (b byteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.ReadAt(p, off)
+}
+
+func TestPatchShortReads(t *testing.T) {
+	newfile := new(bytes.Buffer)
+	basis := byteReaderAt{bytes.NewReader(testdata.RandomData())}
+	if err := Patch(basis, bytes.NewReader(testdata.Delta()), newfile); err != nil {
+		t.Fatalf("Patch failed: %s", err)
+	}
+
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Fatalf("patch result and mutation are not equal")
+	}
+}
+
+// failingReaderAt fails all reads beyond a given offset.
+type failingReaderAt struct {
+	r   io.ReaderAt
+	off int64
+	err error
+}
+
+func (f failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > f.off {
+		if off >= f.off {
+			return 0, f.err
+		}
+		n, _ := f.r.ReadAt(p[:f.off-off], off)
+		return n, f.err
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestPatchBasisError(t *testing.T) {
+	readErr := errors.New("flaky storage")
+	basis := failingReaderAt{bytes.NewReader(testdata.RandomData()), 3000, readErr}
+
+	err := Patch(basis, bytes.NewReader(testdata.Delta()), io.Discard)
+	if !errors.Is(err, readErr) {
+		t.Fatalf("expected the basis read error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "offset 3000") {
+		t.Errorf("error does not name the failing offset: %s", err)
+	}
+
+	var basisErr *BasisError
+	if !errors.As(err, &basisErr) {
+		t.Fatalf("expected a BasisError, got %T", err)
+	}
+	if basisErr.Offset != 3000 || errors.Unwrap(basisErr) != readErr {
+		t.Errorf("unexpected BasisError %+v", basisErr)
+	}
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPatchAndClose(t *testing.T) {
+	basis := struct {
+		*bytes.Reader
+		*closeRecorder
+	}{bytes.NewReader(testdata.RandomData()), new(closeRecorder)}
+	delta := struct {
+		*bytes.Reader
+		*closeRecorder
+	}{bytes.NewReader(testdata.Delta()), new(closeRecorder)}
+	newfile := struct {
+		*bytes.Buffer
+		*closeRecorder
+	}{new(bytes.Buffer), new(closeRecorder)}
+
+	if err := PatchAndClose(basis, delta, newfile); err != nil {
+		t.Fatalf("PatchAndClose failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+	if !basis.closed || !delta.closed || !newfile.closed {
+		t.Errorf("not everything was closed: basis %t, delta %t, newfile %t", basis.closed, delta.closed, newfile.closed)
+	}
+}
+
+func TestBytesHelpers(t *testing.T) {
+	sig, err := SignatureBytes(testdata.RandomData(), Config{BlockLen: 2048, StrongLen: 8, Hash: MD4})
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+	if !bytes.Equal(sig, testdata.RandomDataSig()[0]) {
+		t.Errorf("SignatureBytes returned a different signature")
+	}
+
+	delta, err := DeltaBytes(sig, testdata.Mutation())
+	if err != nil {
+		t.Fatalf("DeltaBytes failed: %s", err)
+	}
+
+	newfile, err := PatchBytes(testdata.RandomData(), delta)
+	if err != nil {
+		t.Fatalf("PatchBytes failed: %s", err)
+	}
+	if !bytes.Equal(newfile, testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+}
+
+func TestPatchChain(t *testing.T) {
+	v1, v2 := testdata.Mutation(), testdata.RandomData()
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4, SpillThreshold: 4096}
+
+	// basis -> v1 -> v2 -> v1
+	sigV1, err := SignatureBytes(v1, config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+	deltaV1V2, err := DeltaBytes(sigV1, v2)
+	if err != nil {
+		t.Fatalf("DeltaBytes failed: %s", err)
+	}
+	sigV2, err := SignatureBytes(v2, config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+	deltaV2V1, err := DeltaBytes(sigV2, v1)
+	if err != nil {
+		t.Fatalf("DeltaBytes failed: %s", err)
+	}
+
+	newfile := new(bytes.Buffer)
+	err = PatchChain(bytes.NewReader(testdata.RandomData()), newfile, config,
+		bytes.NewReader(testdata.Delta()), bytes.NewReader(deltaV1V2), bytes.NewReader(deltaV2V1))
+	if err != nil {
+		t.Fatalf("PatchChain failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), v1) {
+		t.Errorf("result of the chain is wrong")
+	}
+}
+
+func TestCreateSignatureInto(t *testing.T) {
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+	expected := testdata.RandomDataSig()[0]
+
+	for _, size := range []int{len(expected), len(expected) + 10} {
+		dst := make([]byte, size)
+		n, err := CreateSignatureInto(bytes.NewReader(testdata.RandomData()), config, dst)
+		if err != nil {
+			t.Fatalf("CreateSignatureInto failed: %s", err)
+		}
+		if !bytes.Equal(dst[:n], expected) {
+			t.Errorf("signature written into %d bytes differs", size)
+		}
+	}
+
+	dst := make([]byte, len(expected)-1)
+	if _, err := CreateSignatureInto(bytes.NewReader(testdata.RandomData()), config, dst); err != io.ErrShortBuffer {
+		t.Errorf("expected io.ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestEmptyAndShortBasis(t *testing.T) {
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+	newfile := testdata.Mutation()
+
+	for _, basis := range [][]byte{nil, testdata.RandomData()[:100]} {
+		sig, err := SignatureBytes(basis, config)
+		if err != nil {
+			t.Fatalf("%d byte basis: SignatureBytes failed: %s", len(basis), err)
+		}
+
+		loaded, err := LoadSignature(bytes.NewReader(sig))
+		if err != nil {
+			t.Fatalf("%d byte basis: LoadSignature failed: %s", len(basis), err)
+		}
+		blocks, err := loaded.BlockCount()
+		loaded.Close()
+		expected := 1 // the short basis is one partial block
+		if len(basis) == 0 {
+			expected = 0
+		}
+		if err != nil || blocks != expected {
+			t.Errorf("%d byte basis: BlockCount returned %d, %v; expected %d", len(basis), blocks, err, expected)
+		}
+
+		delta, err := DeltaBytes(sig, newfile)
+		if err != nil {
+			t.Fatalf("%d byte basis: DeltaBytes failed: %s", len(basis), err)
+		}
+
+		if len(basis) == 0 {
+			cmds, err := ParseDelta(bytes.NewReader(delta))
+			if err != nil {
+				t.Fatalf("ParseDelta failed: %s", err)
+			}
+			for _, cmd := range cmds {
+				if cmd.Op != DeltaLiteral {
+					t.Errorf("delta against an empty basis has command %v", cmd)
+				}
+			}
+		}
+
+		result, err := PatchBytes(basis, delta)
+		if err != nil {
+			t.Fatalf("%d byte basis: PatchBytes failed: %s", len(basis), err)
+		}
+		if !bytes.Equal(result, newfile) {
+			t.Errorf("%d byte basis: patch result and new file are not equal", len(basis))
+		}
+	}
+}
+
+func TestDeltaSize(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	size, err := DeltaSize(sig, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("DeltaSize failed: %s", err)
+	}
+	if size != int64(len(testdata.Delta())) {
+		t.Errorf("got delta size %d, expected %d", size, len(testdata.Delta()))
+	}
+}
+
+func TestCreateSignatureMulti(t *testing.T) {
+	data := testdata.RandomData()
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+
+	// The golden signature was generated from the whole file, so splits
+	// within and at the ends of blocks must give the same result.
+	for _, splits := range [][]int{{3000}, {2048}, {1}, {8191}, {0, 4000, 4000, 6000}} {
+		var parts []io.Reader
+		last := 0
+		for _, split := range splits {
+			parts = append(parts, bytes.NewReader(data[last:split]))
+			last = split
+		}
+		parts = append(parts, bytes.NewReader(data[last:]))
+
+		sig := new(bytes.Buffer)
+		if err := CreateSignatureMulti(config, sig, parts...); err != nil {
+			t.Fatalf("splits %v: CreateSignatureMulti failed: %s", splits, err)
+		}
+		if !bytes.Equal(sig.Bytes(), testdata.RandomDataSig()[0]) {
+			t.Errorf("splits %v: signature differs from the one of the whole file", splits)
+		}
+	}
+
+	// The sizes of the parts add up for TargetBlockCount.
+	sig := new(bytes.Buffer)
+	config = Config{TargetBlockCount: 4, StrongLen: 8, Hash: MD4}
+	if err := CreateSignatureMulti(config, sig, bytes.NewReader(data[:5000]), bytes.NewReader(data[5000:])); err != nil {
+		t.Fatalf("CreateSignatureMulti failed: %s", err)
+	}
+	if !bytes.Equal(sig.Bytes(), testdata.RandomDataSig()[0]) {
+		t.Errorf("signature with TargetBlockCount differs from the one of the whole file")
+	}
+}
+
+func TestInstantDeltaBounded(t *testing.T) {
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+	sigSize := int64(len(testdata.RandomDataSig()[0]))
+
+	// Half of maxMem is granted to the signature buffer, so the first limit
+	// spills the signature to a temporary file and the second does not.
+	for _, maxMem := range []int64{sigSize, 4 * sigSize} {
+		delta := new(bytes.Buffer)
+		if err := InstantDeltaBounded(bytes.NewReader(testdata.RandomData()), bytes.NewReader(testdata.Mutation()), delta, maxMem, config); err != nil {
+			t.Fatalf("maxMem %d: InstantDeltaBounded failed: %s", maxMem, err)
+		}
+		if !bytes.Equal(delta.Bytes(), testdata.Delta()) {
+			t.Errorf("maxMem %d: delta differs from the expected one", maxMem)
+		}
+	}
+
+	// Without a usable temporary directory, only spilling fails.
+	if runtime.GOOS == "windows" {
+		return
+	}
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	if err := InstantDeltaBounded(bytes.NewReader(testdata.RandomData()), bytes.NewReader(testdata.Mutation()), io.Discard, sigSize, config); err == nil {
+		t.Errorf("signature was not spilled to a temporary file below the limit")
+	}
+	if err := InstantDeltaBounded(bytes.NewReader(testdata.RandomData()), bytes.NewReader(testdata.Mutation()), io.Discard, 4*sigSize, config); err != nil {
+		t.Errorf("signature was spilled to a temporary file above the limit: %s", err)
+	}
+}
+
+func TestCountingWrappers(t *testing.T) {
+	sig := &CountingReader{R: bytes.NewReader(testdata.RandomDataSig()[0])}
+	newfile := &CountingReader{R: bytes.NewReader(testdata.Mutation())}
+	delta := &CountingWriter{W: new(bytes.Buffer)}
+
+	if err := CreateDelta(sig, newfile, delta); err != nil {
+		t.Fatalf("CreateDelta failed: %s", err)
+	}
+	if sig.N != int64(len(testdata.RandomDataSig()[0])) || newfile.N != int64(len(testdata.Mutation())) {
+		t.Errorf("read %d signature and %d new file bytes", sig.N, newfile.N)
+	}
+	if delta.N != int64(len(testdata.Delta())) || delta.W.(*bytes.Buffer).Len() != len(testdata.Delta()) {
+		t.Errorf("counted %d delta bytes, expected %d", delta.N, len(testdata.Delta()))
+	}
+
+	sizes, err := CreateDeltaSizes(bytes.NewReader(testdata.RandomDataSig()[0]), bytes.NewReader(testdata.Mutation()), io.Discard)
+	if err != nil {
+		t.Fatalf("CreateDeltaSizes failed: %s", err)
+	}
+	expected := DeltaSizes{
+		Signature: int64(len(testdata.RandomDataSig()[0])),
+		NewFile:   int64(len(testdata.Mutation())),
+		Delta:     int64(len(testdata.Delta())),
+	}
+	if sizes != expected {
+		t.Errorf("got sizes %+v, expected %+v", sizes, expected)
+	}
+
+	discard := &CountingWriter{}
+	if n, err := discard.Write(make([]byte, 42)); n != 42 || err != nil || discard.N != 42 {
+		t.Errorf("writing to nil writer returned %d, %v, counted %d", n, err, discard.N)
+	}
+}
@@ -0,0 +1,83 @@
+package librsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Fetching signatures over HTTP.
+
+// Number of attempts of SignatureFromURL, and the delay before the first
+// retry, which doubles with every further one.
+const signatureFromURLAttempts = 3
+
+var signatureFromURLDelay = time.Second
+
+// SignatureFromURL fetches url with client and returns the signature of the
+// response body, which is streamed through the signature generation. client
+// controls the timeouts, http.DefaultClient is used if it is nil. A status
+// other than 200 OK is an error.
+//
+// Network errors and server errors (5xx) are retried up to two times, with the
+// signature generation starting over. Cancelling ctx aborts the request and
+// the wait between attempts, and ctx.Err() is returned.
+func SignatureFromURL(ctx context.Context, client *http.Client, url string, config Config) (sig []byte, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	delay := signatureFromURLDelay
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		sig, retry, err = signatureFromURL(ctx, client, url, config)
+		if err == nil || !retry || attempt == signatureFromURLAttempts {
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// signatureFromURL makes one attempt of SignatureFromURL. retry reports
+// whether the error is worth another attempt.
+func signatureFromURL(ctx context.Context, client *http.Client, url string, config Config) (sig []byte, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Retrying is pointless once ctx is done.
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	siggen, err := NewSignatureGen(config, resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	defer siggen.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(siggen); err != nil {
+		// Errors of librsync won't go away, those reading the body might.
+		var rsErr *RsError
+		return nil, !errors.As(err, &rsErr), err
+	}
+	return buf.Bytes(), false, nil
+}
@@ -0,0 +1,71 @@
+package librsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignatureFromURL(t *testing.T) {
+	defer func(delay time.Duration) { signatureFromURLDelay = delay }(signatureFromURLDelay)
+	signatureFromURLDelay = 0
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch {
+		case r.URL.Path != "/random_data":
+			http.NotFound(w, r)
+		case n == 1:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		default:
+			w.Write(testdata.RandomData())
+		}
+	}))
+	defer server.Close()
+
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+	sig, err := SignatureFromURL(context.Background(), server.Client(), server.URL+"/random_data", config)
+	if err != nil {
+		t.Fatalf("SignatureFromURL failed: %s", err)
+	}
+	if !bytes.Equal(sig, testdata.RandomDataSig()[0]) {
+		t.Errorf("signature differs from the expected one")
+	}
+	if n := requests.Swap(0); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+
+	if _, err := SignatureFromURL(context.Background(), server.Client(), server.URL+"/missing", config); err == nil {
+		t.Errorf("SignatureFromURL succeeded for a missing file")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("client errors were retried, got %d requests", n)
+	}
+}
+
+func TestSignatureFromURLCancel(t *testing.T) {
+	defer func(delay time.Duration) { signatureFromURLDelay = delay }(signatureFromURLDelay)
+	signatureFromURLDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails and the client gives up while waiting.
+		cancel()
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := SignatureFromURL(ctx, server.Client(), server.URL, Config{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
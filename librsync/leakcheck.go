@@ -0,0 +1,17 @@
+//go:build leakcheck
+
+package librsync
+
+import (
+	"fmt"
+	"os"
+)
+
+// With the leakcheck build tag, finalizers are enabled and report unclosed jobs
+// and signatures on stderr.
+func init() {
+	leakReport.Store(func(what string, stack []byte) {
+		fmt.Fprintf(os.Stderr, "librsync: %s garbage collected without Close, created at:\n%s\n", what, stack)
+	})
+	EnableFinalizers(true)
+}
@@ -6,16 +6,21 @@ package librsync
 #include <stdio.h>
 #include <librsync.h>
 #include <stdlib.h>
-#include <stdbool.h>
+#include <stdint.h>
 
 static inline rs_buffers_t* new_rs_buffers() {
 	return (rs_buffers_t*) malloc(sizeof(rs_buffers_t));
 }
 
-rs_result patchCallbackGo(void *_patcher, rs_long_t pos, size_t *len, void *_buf);
+rs_result patchCallbackGo(uintptr_t id, rs_long_t pos, size_t *len, void *_buf);
 
-rs_result patchCallback(void* _patcher, rs_long_t pos, size_t* len, void** _buf) {
-	return patchCallbackGo(_patcher, pos, len, _buf);
+rs_result patchCallback(void* id, rs_long_t pos, size_t* len, void** _buf) {
+	return patchCallbackGo((uintptr_t) id, pos, len, _buf);
+}
+
+// The patcher's id is passed as the opaque pointer of the callback.
+static inline rs_job_t* patch_begin(uintptr_t id) {
+	return rs_patch_begin(patchCallback, (void*) id);
 }
 
 #ifndef RS_DEFAULT_STRONG_LEN
@@ -26,15 +31,12 @@ rs_result patchCallback(void* _patcher, rs_long_t pos, size_t* len, void** _buf)
 #define DEFAULT_STRONG_LEN RS_DEFAULT_STRONG_LEN
 #endif
 
-static inline rs_job_t* sig_begin(size_t new_block_len, size_t strong_sum_len, bool compat) {
+static inline rs_job_t* sig_begin(size_t new_block_len, size_t strong_sum_len, unsigned int magic) {
 #ifndef RS_DEFAULT_STRONG_LEN
-	// librsync >= 1.0.0, supporting the newer hash function (blake2b)
-	if (compat) {
-		return rs_sig_begin(new_block_len, strong_sum_len, RS_MD4_SIG_MAGIC);
-	}
-	return rs_sig_begin(new_block_len, strong_sum_len, RS_BLAKE2_SIG_MAGIC);
+	// librsync >= 1.0.0, supporting different hash functions
+	return rs_sig_begin(new_block_len, strong_sum_len, (rs_magic_number) magic);
 #else
-	// not supporting the newer hash function, fall back to the md4 hash
+	// only supporting the md4 hash, which the caller checked
 	return rs_sig_begin(new_block_len, strong_sum_len);
 #endif
 }
@@ -43,13 +45,19 @@ static inline rs_job_t* sig_begin(size_t new_block_len, size_t strong_sum_len, b
 import "C"
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"time"
 	"unsafe"
 )
 
 const (
+	// Default buffer sizes
 	inbufSize  = 16 * 1024
 	outbufSize = 16 * 1024
 )
@@ -57,48 +65,159 @@ const (
 const (
 	DefaultBlockLen  = C.RS_DEFAULT_BLOCK_LEN
 	DefaultStrongLen = C.DEFAULT_STRONG_LEN
+
+	// FullStrongLen is the full length of a BLAKE2 strong sum, which a
+	// StrongLen of 0 stands for with librsync >= 1.0.0. MD4 sums are at most
+	// 16 bytes long. Shorter strong sums make signatures smaller, but raise
+	// the chance that a block with the same rolling checksum is wrongly taken
+	// as a match, which silently corrupts the patched file. Each byte less
+	// makes such a collision 256 times more likely.
+	FullStrongLen = 32
+
+	// DefaultSpillThreshold is the amount of intermediate data helpers keep
+	// in memory before moving it to a temporary file.
+	DefaultSpillThreshold = 64 << 20
+)
+
+// Errors of librsync. Errors of specific operations are RsErrors with the
+// operation set, errors.Is matches them with these by their code.
+var (
+	ErrInputEnded = &RsError{Code: C.RS_INPUT_ENDED, msg: "Input ended (possibly unexpected)"}
+	ErrBadMagic   = &RsError{Code: C.RS_BAD_MAGIC, msg: "Bad magic number. Probably not an librsync file."}
+	ErrCorrupt    = &RsError{Code: C.RS_CORRUPT, msg: "Input stream corrupted"}
+	ErrInternal   = &RsError{Code: C.RS_INTERNAL_ERROR, msg: "Internal error (library bug?)"}
+	ErrIO         = &RsError{Code: C.RS_IO_ERROR, msg: "IO error"}
 )
 
 var (
-	ErrInputEnded = errors.New("Input ended (possibly unexpected)")
-	ErrBadMagic   = errors.New("Bad magic number. Probably not an librsync file.")
-	ErrCorrupt    = errors.New("Input stream corrupted")
-	ErrInternal   = errors.New("Internal error (library bug?)")
+	ErrJobNotFinished    = errors.New("Job was closed before it finished")
+	ErrSignatureTooLarge = errors.New("Signature exceeds the size limit")
+	ErrNilBasis          = errors.New("Basis is nil")
+	ErrNilDelta          = errors.New("Delta is nil")
+
+	ErrOutputLimitExceeded = errors.New("Output exceeds the size limit")
+	ErrSignatureClosed     = errors.New("Signature is closed")
+	ErrRollsumMismatch     = errors.New("Rolling checksum does not match")
+	ErrReadTimeout         = errors.New("Read deadline exceeded")
+	ErrAlreadyClosed       = errors.New("Job is already closed")
+	ErrHashTableNotBuilt   = errors.New("Signature has no hash table, call BuildHashTable first")
+	ErrNotSerialized       = errors.New("Signature was loaded without KeepSerialized")
+	ErrNoCollisionStats    = errors.New("Signature was loaded without CountCollisions")
 )
 
+// RsError is an error result of librsync.
+type RsError struct {
+	Code int    // the rs_result
+	Op   string // the failed librsync function, if known
+
+	msg string
+}
+
+func (e *RsError) Error() string {
+	msg := e.msg
+	if msg == "" {
+		msg = fmt.Sprintf("%s (%d)", C.GoString(C.rs_strerror(C.rs_result(e.Code))), e.Code)
+	}
+	if e.Op != "" {
+		return e.Op + ": " + msg
+	}
+	return msg
+}
+
+// Is reports whether target is an RsError with the same code.
+func (e *RsError) Is(target error) bool {
+	t, ok := target.(*RsError)
+	return ok && t.Code == e.Code
+}
+
+// BasisError is returned by a Patcher if reading its basis failed.
+type BasisError struct {
+	Offset int64 // position in the basis the read failed at
+	Err    error // error of the basis
+}
+
+func (e *BasisError) Error() string {
+	return fmt.Sprintf("patch failed reading basis at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *BasisError) Unwrap() error {
+	return e.Err
+}
+
 // Job holds information about a running librsync operation. The output can be accessed with the Read method.
 type Job struct {
 	rsbufs *C.rs_buffers_t
 	job    *C.rs_job_t
 
-	running bool
-	err     error
+	running  bool
+	closed   bool
+	err      error
+	ctx      context.Context // nil if the job can not be cancelled
+	deadline time.Time       // see SetReadDeadline
+	ioErr    error           // set by callbacks that failed with RS_IO_ERROR
+
+	tracked bool   // has a finalizer, see EnableFinalizers
+	stack   []byte // creation stack for leak reports
+
+	begin   func() *C.rs_job_t // starts the librsync job, used by Reset
+	tee     io.Writer          // see Config.Tee
+	closers []io.Closer        // closed by Close, see Config.CloseInputs
 
-	inbuf unsafe.Pointer
-	in    io.Reader
+	progress func(inBytes, outBytes int64) // see Config.Progress
+	inTotal  int64                         // bytes read from the input
+	outTotal int64                         // bytes produced by librsync
+
+	maxOutput int64 // output limit, if > 0
+
+	hint    *deltaHint // see NewDeltaGenHint
+	dropOut int        // bytes of librsync's output still to drop, see deltaHint
+
+	inbuf     unsafe.Pointer
+	inbufSize int
+	in        io.Reader
 
 	outbufOrig  unsafe.Pointer
 	outbufTotal []byte
 	outbuf      []byte
 }
 
-func newJob(input io.Reader) (job *Job, err error) {
+// SignatureJob, DeltaJob and PatchJob are what code consuming the output of a
+// job needs. They are satisfied by *Job (and *Patcher), so such code can depend
+// on them and be tested with fakes.
+type (
+	SignatureJob interface{ io.ReadCloser }
+	DeltaJob     interface{ io.ReadCloser }
+	PatchJob     interface{ io.ReadCloser }
+)
+
+var (
+	_ SignatureJob = (*Job)(nil)
+	_ DeltaJob     = (*Job)(nil)
+	_ PatchJob     = (*Patcher)(nil)
+)
+
+func newJob(input io.Reader, inSize, outSize int) (job *Job, err error) {
 	job = new(Job)
 
 	job.in = input
-	job.inbuf = C.malloc(inbufSize)
-	job.outbufOrig = C.malloc(outbufSize)
-	job.outbufTotal = (*[outbufSize]byte)(job.outbufOrig)[:]
+	job.inbuf = allocBuffer(inSize)
+	job.inbufSize = inSize
+	job.outbufOrig = allocBuffer(outSize)
+	job.outbufTotal = unsafe.Slice((*byte)(job.outbufOrig), outSize)
 
 	job.rsbufs = C.new_rs_buffers()
 	if job.rsbufs == nil {
+		releaseBuffer(job.inbuf, inSize)
+		releaseBuffer(job.outbufOrig, outSize)
 		return nil, errors.New("Could not allocate memory for rs_buffers_t object")
 	}
 
 	job.rsbufs.eof_in = 0
 	job.rsbufs.avail_in = 0
+	job.rsbufs.avail_out = 0
 
 	job.running = true
+	job.track()
 
 	return
 }
@@ -107,17 +226,212 @@ func newJob(input io.Reader) (job *Job, err error) {
 // values.
 type Config struct {
 	BlockLen  uint // length of a block, e.g. 2048
-	StrongLen uint // length of a strong hash, e.g. 32 or 0
-	CompatMD4 bool // enable for compatibility with librsync < 1.0.0
+	StrongLen uint // length of a strong hash, e.g. 32 or 0, see FullStrongLen
+	CompatMD4 bool // enable for compatibility with librsync < 1.0.0, same as Hash: MD4
+
+	// Hash selects the checksums of the signature. Newer algorithms need
+	// newer versions of librsync, see HashAlgorithm.
+	Hash HashAlgorithm
+
+	// Magic, if set, is the magic number of the generated file, for
+	// compatibility with tools that expect a particular format. For
+	// signatures it must be one of the signature magic numbers the linked
+	// librsync can write, and selects the hash algorithm; Hash must be
+	// DefaultHash or match it. For deltas, only DeltaMagic exists.
+	Magic uint32
+
+	// RollingChecksum selects the rolling checksum of the signature, which
+	// trades speed for match quality. RabinKarp needs librsync >= 2.2.0. It
+	// combines with the strong sum of Hash, which must use the same rolling
+	// checksum if set. The zero value, UnknownRollsum, leaves the choice to
+	// Hash. For delta generation, the signature must use this rolling
+	// checksum, otherwise ErrRollsumMismatch is returned.
+	RollingChecksum RollsumType
+
+	// InBufferSize and OutBufferSize set the sizes of the buffers for
+	// passing data to and from librsync, 16 KiB if zero. Larger buffers mean
+	// fewer calls into librsync. If set, they must be at least one block
+	// long.
+	InBufferSize  int
+	OutBufferSize int
+
+	// TargetBlockCount, if BlockLen is zero, chooses the block length so the
+	// signature has about this many blocks, giving a predictable signature
+	// size. The fewer blocks, the coarser the matching and the larger the
+	// deltas. This needs the size of the basis, see BasisSize.
+	TargetBlockCount uint
+
+	// BasisSize is the size of the basis, used by TargetBlockCount. If zero,
+	// the size is taken from the basis if it has a Size method (like
+	// bytes.Reader or io.SectionReader) or is a regular *os.File.
+	BasisSize int64
+
+	// SpillThreshold limits how much intermediate data helpers like
+	// ComposeDeltas keep in memory, DefaultSpillThreshold if zero.
+	SpillThreshold int64
+
+	// Tee, if set, gets a copy of the basis as the signature generation reads
+	// it, e.g. a hash.Hash to compute a digest of the whole file in the same
+	// pass. Write errors abort the job.
+	Tee io.Writer
+
+	// CloseInputs makes the job close its inputs (basis, new file or delta)
+	// that implement io.Closer when the job is closed. If the job can not be
+	// created, they are closed right away.
+	CloseInputs bool
+
+	// Progress, if set, is called with the number of input bytes processed
+	// and output bytes produced so far, at most once per call to Read, or per
+	// step of WriteTo.
+	Progress func(inBytes, outBytes int64)
+
+	// CompressDelta requests a compressed delta. The delta format reserves
+	// room for compression, but no released version of librsync implements
+	// it, so delta generation returns ErrUnsupported if this is set.
+	CompressDelta bool
+
+	// OnCopy, if set, is called by a Patcher before it reads length bytes at
+	// pos from the basis, e.g. to log the access pattern of a delta. librsync
+	// may split a copy command into several reads. It is called
+	// synchronously in the patch callback, so it should return quickly.
+	OnCopy func(pos, length int64)
+
+	// BasisAdvice is passed to AdviseFile for basis files opened by the file
+	// helpers, like PatchFileAtomicConfig. AdviceRandom suits patching.
+	BasisAdvice Advice
 }
 
-func (c *Config) setup() {
+// maxBlockLen is the largest block length the signature format can hold.
+const maxBlockLen = math.MaxInt32
+
+func (c *Config) setup(basis io.Reader) error {
+	if c.BlockLen == 0 && c.TargetBlockCount > 0 {
+		size := c.BasisSize
+		if size == 0 {
+			var ok bool
+			if size, ok = readerSize(basis); !ok {
+				return errors.New("TargetBlockCount needs the size of the basis")
+			}
+		}
+
+		blockLen := (size + int64(c.TargetBlockCount) - 1) / int64(c.TargetBlockCount)
+		if blockLen > maxBlockLen {
+			return fmt.Errorf("Block length for %d blocks exceeds the maximum of %d", c.TargetBlockCount, maxBlockLen)
+		}
+		c.BlockLen = uint(blockLen)
+	}
+
 	if c.BlockLen == 0 {
 		c.BlockLen = DefaultBlockLen
 	}
 	if c.StrongLen == 0 {
 		c.StrongLen = DefaultStrongLen
 	}
+	if c.BlockLen > maxBlockLen {
+		return fmt.Errorf("Block length %d exceeds the maximum of %d", c.BlockLen, maxBlockLen)
+	}
+
+	if c.Magic != 0 {
+		h := hashByMagic(c.Magic)
+		if h == DefaultHash {
+			return fmt.Errorf("%#x is not a signature magic number", c.Magic)
+		}
+		if c.Hash != DefaultHash && c.Hash != h {
+			return fmt.Errorf("Magic %#x does not match hash algorithm %d", c.Magic, c.Hash)
+		}
+		c.Hash = h
+	}
+
+	if c.RollingChecksum != UnknownRollsum {
+		if c.Hash != DefaultHash && c.Hash.rollsum() != c.RollingChecksum {
+			return fmt.Errorf("%w: hash algorithm %d", ErrRollsumMismatch, c.Hash)
+		}
+		if c.RollingChecksum == RabinKarp {
+			switch c.Hash.resolve(c.CompatMD4) {
+			case MD4:
+				c.Hash = RabinKarpMD4
+			case BLAKE2:
+				c.Hash = RabinKarpBLAKE2
+			}
+		}
+	}
+
+	c.Hash = c.Hash.resolve(c.CompatMD4)
+	if limit := c.Hash.maxStrongLen(); c.StrongLen > limit {
+		return fmt.Errorf("Strong sum length %d exceeds the maximum of %d for hash algorithm %d", c.StrongLen, limit, c.Hash)
+	}
+	return nil
+}
+
+// inputClosers returns those of the inputs of a job that implement io.Closer,
+// if c.CloseInputs is set. If the job could not be created (err != nil), they
+// are closed instead.
+func (c Config) inputClosers(err error, inputs ...interface{}) []io.Closer {
+	if !c.CloseInputs {
+		return nil
+	}
+
+	var closers []io.Closer
+	for _, input := range inputs {
+		if closer, ok := input.(io.Closer); ok {
+			closers = append(closers, closer)
+		}
+	}
+
+	if err != nil {
+		closeAll(closers)
+		return nil
+	}
+	return closers
+}
+
+// closeAll closes all closers and returns the first error.
+func closeAll(closers []io.Closer) (err error) {
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// readerSize determines the size of the data r provides, if possible.
+func readerSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case *os.File:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// bufferSizes returns the configured buffer sizes for a job working with
+// blocks of the given length.
+func (c Config) bufferSizes(blockLen uint) (in, out int, err error) {
+	for _, size := range []int{c.InBufferSize, c.OutBufferSize} {
+		if size < 0 || (size > 0 && uint(size) < blockLen) {
+			return 0, 0, fmt.Errorf("Buffer size %d is smaller than the block length %d", size, blockLen)
+		}
+	}
+
+	in, out = c.InBufferSize, c.OutBufferSize
+	if in == 0 {
+		in = inbufSize
+	}
+	if out == 0 {
+		out = outbufSize
+	}
+	return
+}
+
+func (c Config) spillThreshold() int64 {
+	if c.SpillThreshold == 0 {
+		return DefaultSpillThreshold
+	}
+	return c.SpillThreshold
 }
 
 // NewDefaultSignatureGen is like NewSignatureGen, but uses the default
@@ -131,15 +445,47 @@ func NewDefaultSignatureGen(basis io.Reader) (job *Job, err error) {
 //
 // config is a Config object for more options.
 // basis is an io.Reader that provides data of the basis file.
+//
+// A basis split over several readers can be passed as an io.MultiReader.
+// librsync only sees one stream, so blocks simply span the joins, and the
+// signature is the same as that of the concatenated file. See also
+// CreateSignatureMulti.
 func NewSignatureGen(config Config, basis io.Reader) (job *Job, err error) {
-	job, err = newJob(basis)
+	defer func(basis io.Reader) {
+		if closers := config.inputClosers(err, basis); closers != nil {
+			job.closers = closers
+		}
+	}(basis)
+
+	if err = config.setup(basis); err != nil {
+		return
+	}
+
+	inSize, outSize, err := config.bufferSizes(config.BlockLen)
+	if err != nil {
+		return
+	}
+
+	magic, err := config.Hash.magic()
 	if err != nil {
 		return
 	}
 
-	config.setup()
+	if config.Tee != nil {
+		basis = io.TeeReader(basis, config.Tee)
+	}
+
+	job, err = newJob(basis, inSize, outSize)
+	if err != nil {
+		return
+	}
 
-	job.job = C.sig_begin(C.size_t(config.BlockLen), C.size_t(config.StrongLen), C.bool(config.CompatMD4))
+	job.tee = config.Tee
+	job.progress = config.Progress
+	job.begin = func() *C.rs_job_t {
+		return C.sig_begin(C.size_t(config.BlockLen), C.size_t(config.StrongLen), C.uint(magic))
+	}
+	job.job = job.begin()
 	if job.job == nil {
 		job.Close()
 		return nil, errors.New("rs_sig_begin failed")
@@ -149,7 +495,16 @@ func NewSignatureGen(config Config, basis io.Reader) (job *Job, err error) {
 }
 
 // Close will free memory that Go's garbage collector would not be able to free.
+//
+// Closing a job again does nothing but return ErrAlreadyClosed, so a deferred
+// Close after an explicit one is safe.
 func (job *Job) Close() error {
+	if job.closed {
+		return ErrAlreadyClosed
+	}
+	job.closed = true
+	job.untrack()
+
 	if job.rsbufs != nil {
 		C.free(unsafe.Pointer(job.rsbufs))
 		job.rsbufs = nil
@@ -160,35 +515,104 @@ func (job *Job) Close() error {
 		job.job = nil
 	}
 
-	C.free(job.inbuf)
-	C.free(job.outbufOrig)
+	releaseBuffer(job.inbuf, job.inbufSize)
+	releaseBuffer(job.outbufOrig, len(job.outbufTotal))
+	job.inbuf = nil
+	job.outbufOrig = nil
+
+	err := closeAll(job.closers)
+	job.closers = nil
+	return err
+}
 
+// CloseStrict is like Close, but returns ErrJobNotFinished if the job did not
+// run to its end successfully or not all of its output was read. This catches
+// truncated output, e.g. in tests.
+func (job *Job) CloseStrict() error {
+	finished := job.finished()
+	if err := job.Close(); err != nil {
+		return err
+	}
+	if !finished {
+		return ErrJobNotFinished
+	}
 	return nil
 }
 
-// For errors in callbacks
-type jobInternalPanic struct {
-	err error
+// finished reports whether the job completed and all output was read.
+func (job *Job) finished() bool {
+	return !job.running && job.err == nil && len(job.outbuf) == 0
 }
 
-func (jp jobInternalPanic) Error() string { return jp.err.Error() }
+// Finish ends the input of the job, as if it returned io.EOF: The job does not
+// read from its input anymore and completes with the data read so far. The
+// remaining output is read as usual, with Read or WriteTo. This is for inputs
+// that can not signal their end. Finish always returns nil. Calling it again,
+// also after the job completed or was closed, has no further effect.
+func (job *Job) Finish() error {
+	if job.rsbufs != nil {
+		job.rsbufs.eof_in = 1
+	}
+	return nil
+}
 
-func jobIter(job *C.rs_job_t, rsbufs *C.rs_buffers_t) (running bool, err error) {
-	defer func() {
-		r := recover()
-		if r == nil {
-			// there was no panic
-			return
-		}
-		jp, ok := r.(jobInternalPanic)
-		if !ok {
-			panic(r)
-		}
+// Reset prepares a job to process input from the beginning, as if it was
+// newly created with the same parameters. The buffers of the job are reused,
+// which saves allocations when many jobs of the same kind run one after
+// another, e.g. with a sync.Pool.
+//
+// A Patcher keeps using its basis, and a delta generation job its signature,
+// which must not have been closed. Reset can not be used after Close.
+func (job *Job) Reset(input io.Reader) error {
+	if job.rsbufs == nil {
+		return errors.New("Can not reset a closed job")
+	}
 
-		running = false
-		err = jp.err
-	}()
+	if job.job != nil {
+		C.rs_job_free(job.job)
+		job.job = nil
+	}
+
+	if job.tee != nil {
+		input = io.TeeReader(input, job.tee)
+	}
+	job.in = input
+	job.rsbufs.eof_in = 0
+	job.rsbufs.avail_in = 0
+	job.rsbufs.avail_out = 0
+	job.outbuf = nil
+	job.running = true
+	job.err = nil
+	job.ioErr = nil
+	job.inTotal = 0
+	job.outTotal = 0
+	job.dropOut = 0
+	if job.hint != nil {
+		job.hint.start(job)
+	}
 
+	job.job = job.begin()
+	if job.job == nil {
+		job.running = false
+		job.err = errors.New("Restarting the job failed")
+		return job.err
+	}
+	return nil
+}
+
+// SetReadDeadline makes the job fail with ErrReadTimeout once t has passed.
+// If the input has a SetReadDeadline method, like net.Conn or os.File, the
+// deadline is passed on, so a blocked read returns as well. Otherwise, it is
+// only checked between reads of the input. A zero t removes the deadline.
+func (job *Job) SetReadDeadline(t time.Time) error {
+	job.deadline = t
+	if d, ok := job.in.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func jobIter(job *C.rs_job_t, rsbufs *C.rs_buffers_t) (running bool, err error) {
 	switch res := C.rs_job_iter(job, rsbufs); res {
 	case C.RS_DONE:
 	case C.RS_BLOCKED:
@@ -201,25 +625,23 @@ func jobIter(job *C.rs_job_t, rsbufs *C.rs_buffers_t) (running bool, err error)
 		err = ErrCorrupt
 	case C.RS_INTERNAL_ERROR:
 		err = ErrInternal
+	case C.RS_IO_ERROR:
+		err = ErrIO
 	default:
-		err = fmt.Errorf("Unexpected result from library: %d", res)
+		err = &RsError{Code: int(res), Op: "rs_job_iter"}
 	}
 	return
 }
 
 // Read reads len(p) or less bytes of the generated output.
+//
+// The final output is returned together with io.EOF. If the job fails, the
+// output produced before is returned first, and the error by the next Read.
 func (job *Job) Read(p []byte) (readN int, outerr error) {
 	if len(job.outbuf) > 0 {
-		if len(job.outbuf) > len(p) {
-			readN = len(p)
-		} else {
-			readN = len(job.outbuf)
-		}
-
-		copy(p[:readN], job.outbuf[:readN])
-		p = p[:readN]
+		readN = copy(p, job.outbuf)
 		job.outbuf = job.outbuf[readN:]
-		return
+		return readN, job.eofIfDone()
 	}
 
 	if !job.running {
@@ -230,94 +652,643 @@ func (job *Job) Read(p []byte) (readN int, outerr error) {
 		return 0, io.EOF
 	}
 
+	_, _, err := job.Iterate()
+	job.reportProgress()
+	readN = copy(p, job.outbuf)
+	job.outbuf = job.outbuf[readN:]
+	switch {
+	case err == nil:
+		err = job.eofIfDone()
+	case readN > 0:
+		// Output produced before the error is valid. Deliver it on its own,
+		// so callers that stop at the first error don't lose it. The next
+		// Read returns the error.
+		err = nil
+	}
+	return readN, err
+}
+
+// ReadFrame returns the next output of the job in a newly allocated slice of
+// at most maxFrame bytes, e.g. to send it as a length-prefixed frame. Frames
+// may be shorter than maxFrame, but are never empty. After the last frame,
+// ReadFrame returns io.EOF, or the error that ended the job early.
+func (job *Job) ReadFrame(maxFrame int) ([]byte, error) {
+	if maxFrame <= 0 {
+		return nil, errors.New("Frame size must be positive")
+	}
+
+	for len(job.outbuf) == 0 {
+		if !job.running {
+			if job.err != nil {
+				return nil, job.err
+			}
+			return nil, io.EOF
+		}
+
+		_, _, err := job.Iterate()
+		job.reportProgress()
+		if err != nil && len(job.outbuf) == 0 {
+			return nil, err
+		}
+	}
+
+	n := len(job.outbuf)
+	if n > maxFrame {
+		n = maxFrame
+	}
+	frame := make([]byte, n)
+	job.outbuf = job.outbuf[copy(frame, job.outbuf):]
+	return frame, nil
+}
+
+// eofIfDone returns io.EOF if the job completed and all output was read.
+func (job *Job) eofIfDone() error {
+	if job.finished() {
+		return io.EOF
+	}
+	return nil
+}
+
+// Iterate runs a single step of the job, i.e. reads input if librsync needs
+// more and calls librsync once, and returns the number of output bytes this
+// step produced. It allows driving a job manually, e.g. from an event loop.
+//
+// The output is kept in the job until it is taken out with Read, which returns
+// buffered output without running the job. If the buffer is full, Iterate
+// does nothing, so the output has to be read in between.
+func (job *Job) Iterate() (produced int, running bool, err error) {
+	if !job.running {
+		return 0, false, job.err
+	}
+
+	before := len(job.outbuf)
+	err = job.iter()
+	return len(job.outbuf) - before, job.running, err
+}
+
+// WriteTo writes the generated output to w until the job is done. It returns
+// the number of bytes written and, like Read, the error that ended the job
+// early, if any. io.Copy uses this to hand the output buffer directly to w.
+func (job *Job) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		if len(job.outbuf) > 0 {
+			m, err := w.Write(job.outbuf)
+			n += int64(m)
+			if err == nil && m < len(job.outbuf) {
+				err = io.ErrShortWrite
+			}
+			job.outbuf = job.outbuf[m:]
+			if err != nil {
+				return n, err
+			}
+		}
+
+		if !job.running {
+			return n, job.err
+		}
+
+		// A failing iteration may still have produced output, which is
+		// written before returning the error.
+		job.iter()
+		job.reportProgress()
+	}
+}
+
+// InputPosition returns the number of input bytes librsync consumed so far.
+// Input that was read but is still buffered is not counted. librsync can not
+// save the state of a job, so this does not allow resuming it, but it can be
+// recorded as a checkpoint, e.g. for progress that survives a restart.
+func (job *Job) InputPosition() int64 {
+	if job.rsbufs == nil {
+		return job.inTotal
+	}
+	return job.inTotal - int64(job.rsbufs.avail_in)
+}
+
+// OutputPosition returns the number of output bytes the job returned so far.
+// Output that was produced but not read yet is not counted.
+func (job *Job) OutputPosition() int64 {
+	return job.outTotal - int64(len(job.outbuf))
+}
+
+// reportProgress calls the progress callback, if there is one.
+func (job *Job) reportProgress() {
+	if job.progress != nil {
+		job.progress(job.InputPosition(), job.outTotal)
+	}
+}
+
+// iter fills the input buffer if needed and runs one iteration of the job,
+// which replaces the output buffer. An error ends the job and is recorded in
+// job.err.
+func (job *Job) iter() error {
+	if job.ctx != nil {
+		if err := job.ctx.Err(); err != nil {
+			job.running = false
+			job.err = fmt.Errorf("Job cancelled: %w", err)
+			return job.err
+		}
+	}
+	if !job.deadline.IsZero() && !time.Now().Before(job.deadline) {
+		job.running = false
+		job.err = ErrReadTimeout
+		return job.err
+	}
+
+	// Output that was not read yet is kept, librsync appends to it.
+	pending := copy(job.outbufTotal, job.outbuf)
+	job.outbuf = job.outbufTotal[:pending]
+	free := job.outbufTotal[pending:]
+	if len(free) == 0 {
+		return nil
+	}
+
 	// Fill input buffer
 	if (job.rsbufs.avail_in == 0) && (job.rsbufs.eof_in == 0) {
 		// Turn job.inbuf (C buffer) into a Go slice
-		// https://github.com/golang/go/wiki/cgo#turning-c-arrays-into-go-slices
-		n, err := job.in.Read((*[inbufSize]byte)(job.inbuf)[:])
+		n, err := job.in.Read(unsafe.Slice((*byte)(job.inbuf), job.inbufSize))
 
+		// Data returned together with io.EOF is still passed to librsync
+		// below, along with the end of input.
 		switch err {
 		case nil:
 		case io.EOF:
 			job.rsbufs.eof_in = 1
 		default:
-			outerr = err
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = ErrReadTimeout
+			}
 			job.err = err
 			job.running = false
-			return
+			return err
 		}
 
 		job.rsbufs.next_in = (*C.char)(job.inbuf)
 		job.rsbufs.avail_in = C.size_t(n)
+		job.inTotal += int64(n)
 	}
 
-	job.outbuf = job.outbufTotal
-	job.rsbufs.next_out = (*C.char)(unsafe.Pointer(&(job.outbuf[0])))
-	job.rsbufs.avail_out = C.size_t(len(job.outbuf))
+	job.rsbufs.next_out = (*C.char)(unsafe.Pointer(&free[0]))
+	job.rsbufs.avail_out = C.size_t(len(free))
 
 	var err error
 	job.running, err = jobIter(job.job, job.rsbufs)
+	if err == ErrIO && job.ioErr != nil {
+		// More detailed error from a callback
+		err = job.ioErr
+	}
 
-	outN := int(uintptr(unsafe.Pointer(job.rsbufs.next_out)) - uintptr(unsafe.Pointer(&(job.outbuf[0]))))
-	job.outbuf = job.outbuf[:outN]
+	outN := int(uintptr(unsafe.Pointer(job.rsbufs.next_out)) - uintptr(unsafe.Pointer(&free[0])))
+	if job.dropOut > 0 {
+		drop := job.dropOut
+		if drop > outN {
+			drop = outN
+		}
+		copy(free, free[drop:outN])
+		outN -= drop
+		job.dropOut -= drop
+	}
+	job.outbuf = job.outbufTotal[:pending+outN]
+	job.outTotal += int64(outN)
+
+	if job.maxOutput > 0 && job.outTotal > job.maxOutput {
+		// Output up to the limit is still delivered.
+		job.outbuf = job.outbuf[:len(job.outbuf)-int(job.outTotal-job.maxOutput)]
+		job.running = false
+		err = ErrOutputLimitExceeded
+	}
 
 	if err != nil {
-		return outN, err
+		job.err = err
+	}
+	return err
+}
+
+// BufferState is a snapshot of the buffers librsync works on.
+type BufferState struct {
+	AvailIn  int  // input bytes not yet consumed by librsync
+	AvailOut int  // output space left unused by the last iteration
+	EOFIn    bool // whether the end of the input was reached
+}
+
+// BufferState returns the current state of the job's buffers. This is meant
+// for debugging jobs that do not make progress, e.g. because the input keeps
+// returning no data without an error.
+func (job *Job) BufferState() BufferState {
+	if job.rsbufs == nil {
+		return BufferState{}
+	}
+
+	return BufferState{
+		AvailIn:  int(job.rsbufs.avail_in),
+		AvailOut: int(job.rsbufs.avail_out),
+		EOFIn:    job.rsbufs.eof_in != 0,
 	}
-	return
 }
 
 // Signature is an in-memory representation of a signature.
+//
+// The structures librsync needs for delta generation take about as much
+// memory as the serialized signature. Clone, WriteTo and
+// NewDeltaGenWithDictionary also need the serialized signature, which is only
+// kept if loaded with LoadSignatureOptions.KeepSerialized, doubling the memory
+// used.
+//
+// Copies of a Signature refer to the same loaded signature, so closing one of
+// them closes all.
+//
+// A Signature must not be used by several delta generation jobs at the same
+// time. Searching it is not free of side effects in librsync (newer versions
+// count statistics in it), so concurrent use is a data race. Give each
+// goroutine its own copy made with Clone instead. Other methods only read the
+// parameters or the serialized signature and may be called concurrently, but
+// not concurrently with Close.
 type Signature struct {
-	sig *C.rs_signature_t
+	*signature
+}
+
+type signature struct {
+	sig    *C.rs_signature_t
+	header sigHeader
+	blocks int    // number of blocks
+	raw    []byte // serialized signature, see LoadSignatureOptions.KeepSerialized
+	hashed bool   // the hash table was built
+
+	collisions *CollisionStats // see LoadSignatureOptions.CountCollisions
+
+	tracked bool   // has a finalizer, see EnableFinalizers
+	stack   []byte // creation stack for leak reports
+}
+
+// closed reports whether the signature was closed or never loaded.
+func (s Signature) closed() bool {
+	return s.signature == nil || s.sig == nil
+}
+
+// params returns the header of the serialized signature. ok is false, if the
+// signature is closed.
+func (s Signature) params() (h sigHeader, ok bool) {
+	if s.closed() {
+		return h, false
+	}
+	return s.header, true
 }
 
 // Close will free memory that Go's garbage collector would not be able to free.
 func (s Signature) Close() error {
-	if s.sig != nil {
+	if s.signature != nil {
+		s.untrack()
+	}
+	if !s.closed() {
 		C.rs_free_sumset(s.sig)
 		s.sig = nil
+		s.raw = nil
 	}
 	return nil
 }
 
+// BlockLen returns the block length of the signature, or 0 if the signature
+// is closed.
+func (s Signature) BlockLen() uint {
+	h, _ := s.params()
+	return uint(h.blockLen)
+}
+
+// StrongLen returns the length of the strong sums of the signature, or 0 if
+// the signature is closed.
+func (s Signature) StrongLen() uint {
+	h, _ := s.params()
+	return uint(h.strongLen)
+}
+
+// HashAlgorithm returns the hash algorithm of the signature, or DefaultHash
+// if the signature is closed.
+func (s Signature) HashAlgorithm() HashAlgorithm {
+	h, _ := s.params()
+	return hashByMagic(h.magic)
+}
+
+// BlockCount returns the number of blocks in the signature. Times BlockLen,
+// this gives the size of the signed file, rounded up to a whole block. It
+// returns ErrSignatureClosed if the signature is closed.
+func (s Signature) BlockCount() (int, error) {
+	if s.closed() {
+		return 0, ErrSignatureClosed
+	}
+	return s.blocks, nil
+}
+
+// CollisionStats returns how the weak sums of the signature's blocks collide.
+// It returns ErrNoCollisionStats if the signature was not loaded with
+// LoadSignatureOptions.CountCollisions.
+func (s Signature) CollisionStats() (CollisionStats, error) {
+	if s.closed() {
+		return CollisionStats{}, ErrSignatureClosed
+	}
+	if s.collisions == nil {
+		return CollisionStats{}, ErrNoCollisionStats
+	}
+	return *s.collisions, nil
+}
+
+// RollingChecksum returns the rolling checksum of the signature, or
+// UnknownRollsum if the signature is closed. A peer needs a librsync that
+// supports it to generate deltas from the signature.
+func (s Signature) RollingChecksum() RollsumType {
+	return s.HashAlgorithm().rollsum()
+}
+
+// Clone creates an independent copy of the signature, which has to be closed
+// separately. The copy is loaded again from the serialized signature, so it
+// costs as much memory and time as loading the signature did, but only the
+// structures of librsync are duplicated; the serialized signature is shared.
+// It returns ErrNotSerialized if the signature was not loaded with
+// LoadSignatureOptions.KeepSerialized.
+//
+// Clones can be used at the same time, e.g. to generate deltas of many files
+// against the same basis concurrently.
+func (s Signature) Clone() (Signature, error) {
+	if s.closed() {
+		return Signature{}, errors.New("Can not clone a closed signature")
+	}
+	if s.raw == nil {
+		return Signature{}, ErrNotSerialized
+	}
+
+	clone, err := loadSignature(context.Background(), bytes.NewReader(s.raw), LoadSignatureOptions{BuildHashTable: s.hashed})
+	if err != nil {
+		return Signature{}, err
+	}
+
+	clone.raw = s.raw
+	clone.collisions = s.collisions
+	return clone, nil
+}
+
+// WriteTo writes the signature in its serialized form, as it was loaded, to w.
+// This implements io.WriterTo. It returns ErrNotSerialized if the signature was
+// not loaded with LoadSignatureOptions.KeepSerialized.
+func (s Signature) WriteTo(w io.Writer) (int64, error) {
+	if s.closed() {
+		return 0, errors.New("Can not write a closed signature")
+	}
+	if s.raw == nil {
+		return 0, ErrNotSerialized
+	}
+
+	n, err := w.Write(s.raw)
+	return int64(n), err
+}
+
 // LoadSignature loads a signature to memory.
 func LoadSignature(input io.Reader) (sig Signature, err error) {
-	job, err := newJob(input)
+	return LoadSignatureContext(context.Background(), input)
+}
+
+// LoadSignatureContext is like LoadSignature, but aborts loading once ctx is
+// done. In that case ctx.Err() is returned and everything loaded so far is
+// freed.
+func LoadSignatureContext(ctx context.Context, input io.Reader) (sig Signature, err error) {
+	return loadSignature(ctx, input, LoadSignatureOptions{BuildHashTable: true})
+}
+
+// LoadSignatureOptions controls LoadSignatureWithOptions.
+type LoadSignatureOptions struct {
+	// BuildHashTable builds the hash table that is needed to generate deltas
+	// from the signature. Without it, the signature is only parsed, which
+	// saves time and memory, e.g. to validate an uploaded signature.
+	BuildHashTable bool
+
+	// KeepSerialized keeps a copy of the serialized signature, which Clone,
+	// WriteTo and NewDeltaGenWithDictionary need. This doubles the memory
+	// used by the signature.
+	KeepSerialized bool
+
+	// CountCollisions counts the weak sums while loading, for
+	// Signature.CollisionStats. This needs memory for every distinct weak sum
+	// during the load.
+	CountCollisions bool
+}
+
+// LoadSignatureWithOptions is like LoadSignature, with options.
+func LoadSignatureWithOptions(input io.Reader, opts LoadSignatureOptions) (Signature, error) {
+	return loadSignature(context.Background(), input, opts)
+}
+
+func loadSignature(ctx context.Context, input io.Reader, opts LoadSignatureOptions) (sig Signature, err error) {
+	// Free the partially loaded signature on failure. This is deferred first,
+	// so it runs after the job has been freed.
+	defer func() {
+		if err != nil {
+			sig.Close()
+			sig = Signature{}
+		}
+	}()
+
+	rec := &sigRecorder{r: input}
+	if opts.KeepSerialized {
+		rec.raw = new(bytes.Buffer)
+	}
+	if opts.CountCollisions {
+		rec.collisions = new(collisionCounter)
+	}
+	job, err := newJob(rec, inbufSize, outbufSize)
 	if err != nil {
 		return
 	}
 	defer job.Close()
 
+	sig = Signature{&signature{}}
+	sig.track()
 	job.job = C.rs_loadsig_begin(&(sig.sig))
 	if job.job == nil {
 		err = errors.New("rs_loadsig_begin failed")
 		return
 	}
 
-	if _, err = io.Copy(&nirvana{}, job); err != nil {
+	job.ctx = ctx
+	_, err = io.Copy(&nirvana{}, job)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	if err != nil {
 		return
 	}
 
-	rsret := C.rs_build_hash_table(sig.sig)
-	if rsret != C.RS_DONE {
-		err = fmt.Errorf("rs_build_hash_table returned %d", rsret)
+	if opts.BuildHashTable {
+		if err = sig.BuildHashTable(); err != nil {
+			return
+		}
 	}
 
+	// rs_signature_t is opaque, so the parameters are taken from the
+	// serialized signature.
+	if sig.header, err = parseSigHeader(rec.head); err != nil {
+		return
+	}
+	entries := rec.n - sigHeaderLen
+	if entries%int64(sig.header.entryLen()) != 0 {
+		err = ErrCorrupt
+		return
+	}
+	sig.blocks = int(entries / int64(sig.header.entryLen()))
+	if rec.raw != nil {
+		sig.raw = rec.raw.Bytes()
+	}
+	if rec.collisions != nil {
+		stats := rec.collisions.stats()
+		sig.collisions = &stats
+	}
 	return
 }
 
+// sigRecorder passes a serialized signature through, recording its header and
+// length, all of it if raw is set, and the weak sums if collisions is set.
+type sigRecorder struct {
+	r          io.Reader
+	head       []byte
+	n          int64
+	raw        *bytes.Buffer
+	collisions *collisionCounter
+}
+
+func (rec *sigRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	entries := p[:n]
+	if missing := sigHeaderLen - len(rec.head); missing > 0 {
+		if missing > n {
+			missing = n
+		}
+		rec.head = append(rec.head, p[:missing]...)
+		entries = p[missing:n]
+		if len(rec.head) == sigHeaderLen && rec.collisions != nil {
+			rec.collisions.start(rec.head)
+		}
+	}
+	if rec.collisions != nil && len(rec.head) == sigHeaderLen {
+		rec.collisions.write(entries)
+	}
+	if rec.raw != nil {
+		rec.raw.Write(p[:n])
+	}
+	rec.n += int64(n)
+	return n, err
+}
+
+// BuildHashTable builds the hash table of a signature that was loaded without
+// it, see LoadSignatureOptions. It does nothing if the table was built already.
+func (s Signature) BuildHashTable() error {
+	if s.signature == nil || s.sig == nil {
+		return ErrSignatureClosed
+	}
+	if s.hashed {
+		return nil
+	}
+
+	if rsret := C.rs_build_hash_table(s.sig); rsret != C.RS_DONE {
+		return &RsError{Code: int(rsret), Op: "rs_build_hash_table"}
+	}
+	s.hashed = true
+	return nil
+}
+
+// LoadSignatureLimit is like LoadSignature, but fails with
+// ErrSignatureTooLarge if the signature is longer than maxBytes. Use this for
+// signatures from untrusted sources, as the memory needed grows with the size
+// of the signature: The loaded signature takes about maxBytes of memory at
+// most. maxBytes must not be negative.
+func LoadSignatureLimit(input io.Reader, maxBytes int64) (Signature, error) {
+	if maxBytes < 0 {
+		return Signature{}, errors.New("Signature size limit must not be negative")
+	}
+	return LoadSignature(&sizeGuard{r: input, left: maxBytes})
+}
+
+// sizeGuard passes data through, until more than left bytes were read.
+type sizeGuard struct {
+	r    io.Reader
+	left int64
+}
+
+func (g *sizeGuard) Read(p []byte) (int, error) {
+	if g.left < 0 {
+		return 0, ErrSignatureTooLarge
+	}
+
+	// Reading one byte more than allowed tells whether there is more.
+	if g.left < math.MaxInt64 && int64(len(p)) > g.left+1 {
+		p = p[:g.left+1]
+	}
+
+	n, err := g.r.Read(p)
+	g.left -= int64(n)
+	if g.left < 0 {
+		return 0, ErrSignatureTooLarge
+	}
+	return n, err
+}
+
 // NewDeltaGen creates a delta generation job.
 //
 // sig is the signature loaded by LoadSignature.
 // newfile is a reades that provides the new, modified data.
+//
+// The memory used by the job is bounded no matter how large newfile is:
+// Besides the signature, it only needs fixed size buffers for in- and output
+// and librsync's window of about one block.
+//
+// The signature of an empty basis has no blocks. A delta against it consists
+// of literal data only, so it recreates newfile from nothing, e.g. for the
+// first version of a file.
 func NewDeltaGen(sig Signature, newfile io.Reader) (job *Job, err error) {
-	job, err = newJob(newfile)
+	return NewDeltaGenConfig(Config{}, sig, newfile)
+}
+
+// NewDeltaGenConfig is like NewDeltaGen, but takes a Config for more options.
+// Only the options that apply to delta generation are used.
+func NewDeltaGenConfig(config Config, sig Signature, newfile io.Reader) (job *Job, err error) {
+	defer func() {
+		if closers := config.inputClosers(err, newfile); closers != nil {
+			job.closers = closers
+		}
+	}()
+
+	if sig.closed() {
+		return nil, errors.New("Can not generate a delta from a closed signature")
+	}
+	if !sig.hashed {
+		// librsync would search the missing table and crash or produce
+		// garbage, so any signature not loaded with one is refused.
+		return nil, ErrHashTableNotBuilt
+	}
+	if config.RollingChecksum != UnknownRollsum && sig.RollingChecksum() != config.RollingChecksum {
+		return nil, fmt.Errorf("%w: signature uses rolling checksum %d", ErrRollsumMismatch, sig.RollingChecksum())
+	}
+	if config.CompressDelta {
+		return nil, ErrUnsupported
+	}
+	if config.Magic != 0 && config.Magic != DeltaMagic {
+		return nil, fmt.Errorf("%#x is not the delta magic number", config.Magic)
+	}
+
+	inSize, outSize, err := config.bufferSizes(sig.BlockLen())
+	if err != nil {
+		return
+	}
+
+	job, err = newJob(newfile, inSize, outSize)
 	if err != nil {
 		return
 	}
 
-	job.job = C.rs_delta_begin(sig.sig)
+	job.progress = config.Progress
+	job.begin = func() *C.rs_job_t {
+		if sig.closed() {
+			return nil
+		}
+		return C.rs_delta_begin(sig.sig)
+	}
+	job.job = job.begin()
 	if job.job == nil {
 		job.Close()
 		return nil, errors.New("rs_delta_begin failed")
@@ -332,17 +1303,52 @@ func NewDeltaGen(sig Signature, newfile io.Reader) (job *Job, err error) {
 type Patcher struct {
 	*Job
 	basis io.ReaderAt
-	buf   unsafe.Pointer
-}
+	id    uintptr // key in the patcher store
 
-var patchCallback = C.patchCallback // So we can use the `&` operator in NewPatcher
+	copyBytes int64 // bytes read from the basis
+	onCopy    func(pos, length int64)
+
+	deltaAt  *io.SectionReader // the delta, if it can be read a second time
+	expected int64             // output size implied by the delta, -1 if unknown
+
+	// buffer for the patch callback, see buffer
+	mem      patchMem
+	buf      unsafe.Pointer
+	bufSize  int
+	bufHigh  int // largest size requested in the current window
+	bufCalls int // callbacks in the current window
+}
 
 // NewPatcher creates a Patcher (which basically is a Job object with some hidden extra data).
 //
 // delta is a reader that provides the delta.
 // basis provides the basis file.
 func NewPatcher(delta io.Reader, basis io.ReaderAt) (job *Patcher, err error) {
-	_job, e := newJob(delta)
+	return NewPatcherConfig(Config{}, delta, basis)
+}
+
+// NewPatcherConfig is like NewPatcher, but takes a Config for more options.
+// Only the options that apply to patching are used.
+func NewPatcherConfig(config Config, delta io.Reader, basis io.ReaderAt) (job *Patcher, err error) {
+	defer func() {
+		if closers := config.inputClosers(err, delta, basis); closers != nil {
+			job.closers = closers
+		}
+	}()
+
+	if basis == nil {
+		return nil, ErrNilBasis
+	}
+	if delta == nil {
+		return nil, ErrNilDelta
+	}
+
+	inSize, outSize, err := config.bufferSizes(0)
+	if err != nil {
+		return
+	}
+
+	_job, e := newJob(delta, inSize, outSize)
 	if e != nil {
 		err = e
 		return
@@ -350,28 +1356,126 @@ func NewPatcher(delta io.Reader, basis io.ReaderAt) (job *Patcher, err error) {
 
 	job = &Patcher{
 		Job:   _job,
-		basis: basis}
-
-	id := uintptr(unsafe.Pointer(_job.rsbufs)) // this is a unique, unchanging number (C doesn't change pointers under the hood)
-	storePatcher(job, id)
-	job.job = C.rs_patch_begin((*C.rs_copy_cb)(patchCallback), unsafe.Pointer(_job.rsbufs))
+		basis: basis,
+		id:    newPatcherID()}
+
+	storePatcher(job, job.id)
+	job.setDelta(delta)
+	job.onCopy = config.OnCopy
+	job.progress = config.Progress
+	job.begin = func() *C.rs_job_t {
+		return C.patch_begin(C.uintptr_t(job.id))
+	}
+	job.job = job.begin()
 	if job.job == nil {
-		dropPatcher(id)
-		job.Close()
+		dropPatcher(job.id)
+		job.Job.Close()
 		return nil, errors.New("rs_patch_begin failed")
 	}
 
 	return
 }
 
+// NewPatcherLimit is like NewPatcher, but the patcher fails with
+// ErrOutputLimitExceeded once it produced more than maxOutput bytes. The
+// output up to the limit is still returned. Use this for deltas from untrusted
+// sources, which could describe arbitrarily large files.
+func NewPatcherLimit(delta io.Reader, basis io.ReaderAt, maxOutput int64) (*Patcher, error) {
+	if maxOutput <= 0 {
+		return nil, errors.New("Output limit must be positive")
+	}
+
+	patcher, err := NewPatcher(delta, basis)
+	if err != nil {
+		return nil, err
+	}
+
+	patcher.maxOutput = maxOutput
+	return patcher, nil
+}
+
 // Close unreferences memory that the garbage collector would not otherwise be
-// able to free.
+// able to free. Like Job.Close, it returns ErrAlreadyClosed if called again.
 func (patch *Patcher) Close() error {
-	dropPatcher(uintptr(unsafe.Pointer(patch.Job.rsbufs)))
-
-	if patch.buf != nil {
-		C.free(patch.buf)
+	if patch.Job.closed {
+		return ErrAlreadyClosed
 	}
+	dropPatcher(patch.id)
+
+	patch.freeBuffer()
 
 	return patch.Job.Close()
 }
+
+// Reset is like Job.Reset, and also resets the byte counters of the patcher.
+func (patch *Patcher) Reset(delta io.Reader) error {
+	patch.copyBytes = 0
+	patch.setDelta(delta)
+	return patch.Job.Reset(delta)
+}
+
+// setDelta remembers where delta starts, if it can be read a second time
+// without disturbing the patcher, for ExpectedSize.
+func (patch *Patcher) setDelta(delta io.Reader) {
+	patch.deltaAt = nil
+	patch.expected = -1
+
+	ra, ok := delta.(io.ReaderAt)
+	if !ok {
+		return
+	}
+	seeker, ok := delta.(io.Seeker)
+	if !ok {
+		return
+	}
+	if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+		patch.deltaAt = io.NewSectionReader(ra, start, math.MaxInt64-start)
+	}
+}
+
+// ExpectedSize returns the size of the patched file, and whether it is known.
+// The delta format does not record it, so it is only known once patching
+// finished, or if the delta implements io.ReaderAt and io.Seeker (like
+// bytes.Reader or os.File). The commands of the delta are summed up then,
+// which reads the delta a second time on the first call.
+func (patch *Patcher) ExpectedSize() (int64, bool) {
+	if !patch.running && patch.err == nil {
+		return patch.outTotal, true
+	}
+
+	if patch.deltaAt != nil {
+		if size, err := deltaOutputSize(patch.deltaAt); err == nil {
+			patch.expected = size
+		}
+		patch.deltaAt = nil
+	}
+	return patch.expected, patch.expected >= 0
+}
+
+// CopyBytes returns the number of bytes of the output so far that were copied
+// from the basis.
+func (patch *Patcher) CopyBytes() int64 {
+	return patch.copyBytes
+}
+
+// LiteralBytes returns the number of bytes of the output so far that came from
+// literal data in the delta.
+func (patch *Patcher) LiteralBytes() int64 {
+	if lit := patch.outTotal - patch.copyBytes; lit > 0 {
+		return lit
+	}
+	return 0
+}
+
+// CloseStrict is like Close, but returns ErrJobNotFinished if patching did not
+// run to its end successfully, see Job.CloseStrict.
+func (patch *Patcher) CloseStrict() error {
+	finished := patch.finished()
+	if err := patch.Close(); err != nil {
+		return err
+	}
+	if !finished {
+		return ErrJobNotFinished
+	}
+	return nil
+}
@@ -13,25 +13,79 @@ import (
 )
 
 //export patchCallbackGo
-func patchCallbackGo(_patcher uintptr, pos C.rs_long_t, buflen *C.size_t, buf *unsafe.Pointer) C.rs_result {
-	patcher := getPatcher(_patcher)
+func patchCallbackGo(id uintptr, pos C.rs_long_t, buflen *C.size_t, buf *unsafe.Pointer) C.rs_result {
+	patcher := getPatcher(id)
+	if patcher.onCopy != nil {
+		patcher.onCopy(int64(pos), int64(*buflen))
+	}
 
-	if patcher.buf != nil {
-		C.free(patcher.buf)
+	s := unsafe.Slice((*byte)(patcher.buffer(int(*buflen))), *buflen)
+
+	// ReaderAts may return less than requested, so read until the buffer is
+	// full or an error occurs.
+	n := 0
+	var err error
+	for n < len(s) && err == nil {
+		var m int
+		m, err = patcher.basis.ReadAt(s[n:], int64(pos)+int64(n))
+		if m == 0 && err == nil {
+			err = io.ErrNoProgress
+		}
+		n += m
 	}
-	patcher.buf = C.malloc(*buflen)
-	// https://github.com/golang/go/wiki/cgo#turning-c-arrays-into-go-slices
-	s := (*[1 << 30]byte)(patcher.buf)[:*buflen:*buflen]
-	n, err := patcher.basis.ReadAt(s, int64(pos))
 	if n < int(*buflen) {
 		if err != io.EOF {
-			panic(jobInternalPanic{err})
-		} else {
-			return C.RS_INPUT_ENDED
+			patcher.ioErr = &BasisError{Offset: int64(pos) + int64(n), Err: err}
+			return C.RS_IO_ERROR
 		}
+		return C.RS_INPUT_ENDED
 	}
 	*buflen = C.size_t(n)
 	*buf = patcher.buf
+	patcher.copyBytes += int64(n)
 
 	return C.RS_DONE
 }
+
+// patchBufWindow is the number of callbacks after which the patch buffer may
+// be shrunk.
+const patchBufWindow = 64
+
+// buffer returns a buffer of at least size bytes for the patch callback. The
+// buffer is reused by following calls, and only grows if a larger one is
+// needed. So a few large copies don't keep a large buffer around, it is shrunk
+// to the largest size requested in the last patchBufWindow calls if that is
+// less than half of its size.
+func (patcher *Patcher) buffer(size int) unsafe.Pointer {
+	if size > patcher.bufHigh {
+		patcher.bufHigh = size
+	}
+	patcher.bufCalls++
+
+	if patcher.bufCalls >= patchBufWindow {
+		if patcher.bufSize > 2*patcher.bufHigh {
+			patcher.resizeBuffer(patcher.bufHigh)
+		}
+		patcher.bufCalls = 0
+		patcher.bufHigh = 0
+	}
+
+	if patcher.buf == nil || patcher.bufSize < size {
+		patcher.resizeBuffer(size)
+	}
+	return patcher.buf
+}
+
+func (patcher *Patcher) resizeBuffer(size int) {
+	patcher.freeBuffer()
+	patcher.buf = patcher.mem.alloc(size)
+	patcher.bufSize = size
+}
+
+func (patcher *Patcher) freeBuffer() {
+	if patcher.buf != nil {
+		patcher.mem.free()
+		patcher.buf = nil
+		patcher.bufSize = 0
+	}
+}
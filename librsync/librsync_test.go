@@ -2,11 +2,26 @@ package librsync
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/silvasur/golibrsync/librsync/testdata"
 	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os/exec"
+	"runtime"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unsafe"
 )
 
+var update = flag.Bool("update", false, "regenerate the golden files in testdata with the linked librsync")
+
 func TestSignatureDeltaPatch(t *testing.T) {
 	// Generate signature
 	orig := bytes.NewReader(testdata.RandomData())
@@ -86,3 +101,1291 @@ func TestSignatureDeltaPatch(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadSignatureContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sig, err := LoadSignatureContext(ctx, bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != context.Canceled {
+		t.Fatalf("expected %s, got %v", context.Canceled, err)
+	}
+	if sig.signature != nil {
+		t.Fatalf("cancelled load returned a signature")
+	}
+}
+
+// readChunked reads r to the end, using reads of the given size.
+func readChunked(r io.Reader, size int) ([]byte, error) {
+	out := new(bytes.Buffer)
+	buf := make([]byte, size)
+	for {
+		n, err := r.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestSignatureReproducible(t *testing.T) {
+	inputs := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"plain", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"half", iotest.HalfReader},
+		{"data with EOF", iotest.DataErrReader},
+	}
+
+	var reference []byte
+	for _, input := range inputs {
+		for _, chunk := range []int{1, 13, 4096, 1 << 16} {
+			siggen, err := NewDefaultSignatureGen(input.wrap(bytes.NewReader(testdata.RandomData())))
+			if err != nil {
+				t.Fatalf("could not create a signature generator: %s", err)
+			}
+
+			sig, err := readChunked(siggen, chunk)
+			siggen.Close()
+			if err != nil {
+				t.Fatalf("%s input, %d byte reads: creating the signature failed: %s", input.name, chunk, err)
+			}
+
+			if reference == nil {
+				reference = sig
+			} else if !bytes.Equal(sig, reference) {
+				t.Errorf("%s input, %d byte reads: signature differs", input.name, chunk)
+			}
+		}
+	}
+
+	matches := false
+	for _, sigcheck := range testdata.RandomDataSig() {
+		if bytes.Equal(reference, sigcheck) {
+			matches = true
+		}
+	}
+	if !matches {
+		t.Errorf("signature does not match the reference signature")
+	}
+}
+
+func TestDeltaGenConstantMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large delta generation in short mode")
+	}
+
+	sigbuf := new(bytes.Buffer)
+	if err := CreateSignature(bytes.NewReader(testdata.RandomData()), sigbuf); err != nil {
+		t.Fatalf("CreateSignature failed: %s", err)
+	}
+	sig, err := LoadSignature(sigbuf)
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	const size = 256 << 20
+	newfile := io.LimitReader(rand.New(rand.NewSource(1)), size)
+
+	deltagen, err := NewDeltaGen(sig, newfile)
+	if err != nil {
+		t.Fatalf("could not create a delta generator: %s", err)
+	}
+	defer deltagen.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	buf := make([]byte, 32*1024)
+	var maxHeap uint64
+	for i := 0; ; i++ {
+		_, err := deltagen.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Creating the delta failed: %s", err)
+		}
+
+		if i%1024 == 0 {
+			runtime.ReadMemStats(&after)
+			if after.HeapInuse > maxHeap {
+				maxHeap = after.HeapInuse
+			}
+		}
+	}
+
+	if maxHeap > before.HeapInuse+16<<20 {
+		t.Errorf("heap grew from %d to %d bytes while generating a delta of %d bytes", before.HeapInuse, maxHeap, size)
+	}
+}
+
+func TestSignatureClone(t *testing.T) {
+	sig, err := LoadSignatureWithOptions(bytes.NewReader(testdata.RandomDataSig()[0]), LoadSignatureOptions{BuildHashTable: true, KeepSerialized: true})
+	if err != nil {
+		t.Fatalf("Loading signature failed: %s", err)
+	}
+
+	clone, err := sig.Clone()
+	if err != nil {
+		t.Fatalf("Cloning signature failed: %s", err)
+	}
+	defer clone.Close()
+
+	// The clone must stay usable after closing the original.
+	sig.Close()
+
+	plain, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("Loading signature failed: %s", err)
+	}
+	defer plain.Close()
+	if _, err := plain.Clone(); err != ErrNotSerialized {
+		t.Errorf("cloning without the serialized signature: expected ErrNotSerialized, got %v", err)
+	}
+
+	delta := new(bytes.Buffer)
+	deltagen, err := NewDeltaGen(clone, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("could not create a delta generator: %s", err)
+	}
+	defer deltagen.Close()
+
+	if _, err = io.Copy(delta, deltagen); err != nil {
+		t.Fatalf("Creating the delta failed: %s", err)
+	}
+	if !bytes.Equal(delta.Bytes(), testdata.Delta()) {
+		t.Fatalf("delta generated with the clone does not match")
+	}
+}
+
+func TestSignatureCloneConcurrent(t *testing.T) {
+	sig, err := LoadSignatureWithOptions(bytes.NewReader(testdata.RandomDataSig()[0]), LoadSignatureOptions{BuildHashTable: true, KeepSerialized: true})
+	if err != nil {
+		t.Fatalf("Loading signature failed: %s", err)
+	}
+	defer sig.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		clone, err := sig.Clone()
+		if err != nil {
+			t.Fatalf("Cloning signature failed: %s", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer clone.Close()
+
+			delta, err := DeltaSize(clone, bytes.NewReader(testdata.Mutation()))
+			if err == nil && delta != int64(len(testdata.Delta())) {
+				err = fmt.Errorf("got a delta of %d bytes", delta)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent delta generation failed: %s", err)
+		}
+	}
+}
+
+func TestTargetBlockCount(t *testing.T) {
+	config := Config{TargetBlockCount: 3}
+	if err := config.setup(bytes.NewReader(testdata.RandomData())); err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	if config.BlockLen != 2731 {
+		t.Errorf("expected a block length of 2731, got %d", config.BlockLen)
+	}
+
+	config = Config{TargetBlockCount: 3}
+	if err := config.setup(io.MultiReader()); err == nil {
+		t.Errorf("setup did not fail for a basis of unknown size")
+	}
+
+	config = Config{TargetBlockCount: 3, BasisSize: 300}
+	if err := config.setup(io.MultiReader()); err != nil || config.BlockLen != 100 {
+		t.Errorf("expected a block length of 100, got %d (error: %v)", config.BlockLen, err)
+	}
+}
+
+func TestConfigStrongLen(t *testing.T) {
+	for _, tc := range []struct {
+		hash      HashAlgorithm
+		strongLen uint
+		ok        bool
+	}{
+		{MD4, 16, true},
+		{MD4, 17, false},
+		{BLAKE2, FullStrongLen, true},
+		{BLAKE2, FullStrongLen + 1, false},
+		{RabinKarpMD4, FullStrongLen, false},
+	} {
+		config := Config{Hash: tc.hash, StrongLen: tc.strongLen}
+		if err := config.setup(nil); (err == nil) != tc.ok {
+			t.Errorf("hash %d, strong length %d: unexpected result %v", tc.hash, tc.strongLen, err)
+		}
+	}
+}
+
+func TestDeltaGenContextCancelled(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("Loading signature failed: %s", err)
+	}
+	defer sig.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deltagen, err := NewDeltaGenContext(ctx, sig, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("could not create a delta generator: %s", err)
+	}
+	defer deltagen.Close()
+
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		if _, err := deltagen.Read(make([]byte, 1024)); !errors.Is(err, context.Canceled) {
+			t.Fatalf("read %d: expected %s, got %v", i, context.Canceled, err)
+		}
+	}
+}
+
+func BenchmarkDeltaGenBufferSizes(b *testing.B) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		b.Fatalf("Loading signature failed: %s", err)
+	}
+	defer sig.Close()
+
+	newfile := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(newfile)
+
+	for _, size := range []int{16 << 10, 64 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			config := Config{InBufferSize: size, OutBufferSize: size}
+			b.SetBytes(int64(len(newfile)))
+
+			for i := 0; i < b.N; i++ {
+				deltagen, err := NewDeltaGenConfig(config, sig, bytes.NewReader(newfile))
+				if err != nil {
+					b.Fatalf("could not create a delta generator: %s", err)
+				}
+				if _, err = io.Copy(io.Discard, deltagen); err != nil {
+					b.Fatalf("Creating the delta failed: %s", err)
+				}
+				deltagen.Close()
+			}
+		})
+	}
+}
+
+func TestSignatureAccessors(t *testing.T) {
+	siggen, err := NewSignatureGen(Config{BlockLen: 1024, StrongLen: 8, Hash: MD4}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	sig, err := LoadSignature(siggen)
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	copied := sig
+
+	if sig.BlockLen() != 1024 || sig.StrongLen() != 8 || sig.HashAlgorithm() != MD4 {
+		t.Errorf("unexpected parameters: block length %d, strong length %d, hash %d", sig.BlockLen(), sig.StrongLen(), sig.HashAlgorithm())
+	}
+
+	sig.Close()
+	if copied.BlockLen() != 0 || copied.StrongLen() != 0 || copied.HashAlgorithm() != DefaultHash {
+		t.Errorf("closed signature reports parameters")
+	}
+	if (Signature{}).BlockLen() != 0 {
+		t.Errorf("zero value signature reports a block length")
+	}
+}
+
+func TestSignatureGenTee(t *testing.T) {
+	tee := new(bytes.Buffer)
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4, Tee: tee}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	sig := new(bytes.Buffer)
+	if _, err := io.Copy(sig, siggen); err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+
+	if !bytes.Equal(sig.Bytes(), testdata.RandomDataSig()[0]) {
+		t.Errorf("signature differs from the one generated without Tee")
+	}
+	if !bytes.Equal(tee.Bytes(), testdata.RandomData()) {
+		t.Errorf("Tee did not get a copy of the basis")
+	}
+}
+
+func TestJobReset(t *testing.T) {
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	// Stop in the middle of the first input
+	if _, err := siggen.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := siggen.Reset(bytes.NewReader(testdata.RandomData())); err != nil {
+			t.Fatalf("Reset failed: %s", err)
+		}
+
+		sig := new(bytes.Buffer)
+		if _, err := io.Copy(sig, siggen); err != nil {
+			t.Fatalf("signature generation failed: %s", err)
+		}
+		if !bytes.Equal(sig.Bytes(), testdata.RandomDataSig()[0]) {
+			t.Fatalf("signature of reset job #%d differs", i)
+		}
+	}
+
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+
+	for i := 0; i < 2; i++ {
+		newfile := new(bytes.Buffer)
+		if _, err := io.Copy(newfile, patcher); err != nil {
+			t.Fatalf("patching failed: %s", err)
+		}
+		if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+			t.Fatalf("patch result #%d and mutation are not equal", i)
+		}
+
+		if err := patcher.Reset(bytes.NewReader(testdata.Delta())); err != nil {
+			t.Fatalf("Reset failed: %s", err)
+		}
+	}
+}
+
+func BenchmarkSignatureGen(b *testing.B) {
+	data := testdata.RandomData()
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+
+	b.Run("fresh", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			siggen, err := NewSignatureGen(config, bytes.NewReader(data))
+			if err != nil {
+				b.Fatalf("NewSignatureGen failed: %s", err)
+			}
+			if _, err := io.Copy(io.Discard, siggen); err != nil {
+				b.Fatalf("signature generation failed: %s", err)
+			}
+			siggen.Close()
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		var pool sync.Pool
+		for i := 0; i < b.N; i++ {
+			siggen, _ := pool.Get().(*Job)
+			if siggen == nil {
+				var err error
+				if siggen, err = NewSignatureGen(config, bytes.NewReader(data)); err != nil {
+					b.Fatalf("NewSignatureGen failed: %s", err)
+				}
+			} else if err := siggen.Reset(bytes.NewReader(data)); err != nil {
+				b.Fatalf("Reset failed: %s", err)
+			}
+
+			if _, err := io.Copy(io.Discard, siggen); err != nil {
+				b.Fatalf("signature generation failed: %s", err)
+			}
+			pool.Put(siggen)
+		}
+
+		if siggen, _ := pool.Get().(*Job); siggen != nil {
+			siggen.Close()
+		}
+	})
+}
+
+// BenchmarkPatch exercises the patch callback buffer. Compare with a run
+// using -tags patchmalloc for the malloc based buffer.
+func BenchmarkPatch(b *testing.B) {
+	basis := bytes.NewReader(testdata.RandomData())
+	delta := testdata.Delta()
+
+	for i := 0; i < b.N; i++ {
+		patcher, err := NewPatcher(bytes.NewReader(delta), basis)
+		if err != nil {
+			b.Fatalf("NewPatcher failed: %s", err)
+		}
+		if _, err := io.Copy(io.Discard, patcher); err != nil {
+			b.Fatalf("patching failed: %s", err)
+		}
+		patcher.Close()
+	}
+}
+
+// BenchmarkPatchMem compares the memory behind the patch callback buffer
+// alone, without librsync: A buffer is allocated and filled from the basis,
+// as a callback that had to grow it does. Compare with -tags patchmalloc.
+func BenchmarkPatchMem(b *testing.B) {
+	basis := bytes.NewReader(bytes.Repeat(testdata.RandomData(), 128))
+
+	for _, size := range []int{4 << 10, 64 << 10, 1 << 20} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			var mem patchMem
+			defer mem.free()
+
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				buf := unsafe.Slice((*byte)(mem.alloc(size)), size)
+				if _, err := basis.ReadAt(buf, 0); err != nil {
+					b.Fatalf("ReadAt failed: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCloseStrict(t *testing.T) {
+	for _, drain := range []bool{false, true} {
+		patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+		if err != nil {
+			t.Fatalf("NewPatcher failed: %s", err)
+		}
+
+		if drain {
+			_, err = io.Copy(io.Discard, patcher)
+		} else {
+			_, err = patcher.Read(make([]byte, 10))
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+
+		err = patcher.CloseStrict()
+		if drain && err != nil {
+			t.Errorf("CloseStrict of a finished job failed: %s", err)
+		}
+		if !drain && err != ErrJobNotFinished {
+			t.Errorf("expected ErrJobNotFinished, got %v", err)
+		}
+	}
+}
+
+func TestSignatureWriteTo(t *testing.T) {
+	for _, raw := range testdata.RandomDataSig() {
+		sig, err := LoadSignature(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("LoadSignature failed: %s", err)
+		}
+		_, err = sig.WriteTo(new(bytes.Buffer))
+		sig.Close()
+		if err != ErrNotSerialized {
+			t.Errorf("expected ErrNotSerialized without KeepSerialized, got %v", err)
+		}
+
+		sig, err = LoadSignatureWithOptions(bytes.NewReader(raw), LoadSignatureOptions{KeepSerialized: true})
+		if err != nil {
+			t.Fatalf("LoadSignatureWithOptions failed: %s", err)
+		}
+
+		out := new(bytes.Buffer)
+		n, err := sig.WriteTo(out)
+		sig.Close()
+		if err != nil {
+			t.Fatalf("WriteTo failed: %s", err)
+		}
+		if n != int64(len(raw)) || !bytes.Equal(out.Bytes(), raw) {
+			t.Errorf("written signature differs from the loaded one")
+		}
+
+		if _, err := sig.WriteTo(out); err == nil {
+			t.Errorf("WriteTo of a closed signature succeeded")
+		}
+	}
+}
+
+func TestRsError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &RsError{Code: ErrCorrupt.Code, Op: "rs_job_iter"})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("error with the code of ErrCorrupt does not match it")
+	}
+	if errors.Is(err, ErrBadMagic) {
+		t.Errorf("error with the code of ErrCorrupt matches ErrBadMagic")
+	}
+
+	var rsErr *RsError
+	if !errors.As(err, &rsErr) || rsErr.Op != "rs_job_iter" {
+		t.Errorf("errors.As did not find the RsError")
+	}
+
+	err = Patch(bytes.NewReader(testdata.RandomData()), bytes.NewReader([]byte("not a delta")), io.Discard)
+	if !errors.As(err, &rsErr) || rsErr.Code != ErrBadMagic.Code {
+		t.Errorf("expected an RsError with the code of ErrBadMagic, got %v", err)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+
+	// Drive the job manually, taking out only part of the output each time.
+	newfile := new(bytes.Buffer)
+	buf := make([]byte, 1000)
+	buffered := 0
+	for running := true; running; {
+		produced, r, err := patcher.Iterate()
+		if err != nil {
+			t.Fatalf("Iterate failed: %s", err)
+		}
+		running = r
+		buffered += produced
+
+		if buffered > 0 {
+			n, _ := patcher.Read(buf[:buffered%len(buf)+1])
+			newfile.Write(buf[:n])
+			buffered -= n
+		}
+	}
+
+	rest, err := io.ReadAll(patcher)
+	if err != nil {
+		t.Fatalf("reading the rest failed: %s", err)
+	}
+	newfile.Write(rest)
+
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Fatalf("patch result and mutation are not equal")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var calls int
+	var lastIn, lastOut int64
+	progress := func(inBytes, outBytes int64) {
+		if inBytes < lastIn || outBytes < lastOut {
+			t.Errorf("progress went backwards: %d, %d after %d, %d", inBytes, outBytes, lastIn, lastOut)
+		}
+		calls++
+		lastIn, lastOut = inBytes, outBytes
+	}
+
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4, InBufferSize: 2048, Progress: progress}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	sig, err := readChunked(siggen, 16)
+	if err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+
+	if calls < 2 {
+		t.Errorf("expected several progress reports, got %d", calls)
+	}
+	if lastIn != int64(len(testdata.RandomData())) || lastOut != int64(len(sig)) {
+		t.Errorf("expected final progress %d, %d; got %d, %d", len(testdata.RandomData()), len(sig), lastIn, lastOut)
+	}
+}
+
+func TestPatchBuffer(t *testing.T) {
+	patcher := &Patcher{}
+	defer patcher.freeBuffer()
+
+	buf := patcher.buffer(100)
+	if patcher.buffer(50) != buf {
+		t.Errorf("smaller request did not reuse the buffer")
+	}
+
+	patcher.buffer(100000)
+	if patcher.bufSize != 100000 {
+		t.Fatalf("buffer did not grow, size %d", patcher.bufSize)
+	}
+
+	for i := 0; i < 2*patchBufWindow; i++ {
+		patcher.buffer(10)
+	}
+	if patcher.bufSize > 20 {
+		t.Errorf("buffer was not shrunk after many small requests, size %d", patcher.bufSize)
+	}
+}
+
+func TestCompressDeltaUnsupported(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	if _, err := NewDeltaGenConfig(Config{CompressDelta: true}, sig, bytes.NewReader(testdata.Mutation())); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestLoadSignatureLimit(t *testing.T) {
+	raw := testdata.RandomDataSig()[0]
+
+	sig, err := LoadSignatureLimit(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("LoadSignatureLimit failed at the exact size: %s", err)
+	}
+	sig.Close()
+
+	// Cutting off one block would still be a valid signature, so this must
+	// be detected as too large, not loaded.
+	if _, err := LoadSignatureLimit(bytes.NewReader(raw), int64(len(raw)-12)); err != ErrSignatureTooLarge {
+		t.Errorf("expected ErrSignatureTooLarge, got %v", err)
+	}
+
+	sig, err = LoadSignatureLimit(bytes.NewReader(raw), math.MaxInt64)
+	if err != nil {
+		t.Fatalf("LoadSignatureLimit failed without an effective limit: %s", err)
+	}
+	sig.Close()
+
+	if _, err := LoadSignatureLimit(bytes.NewReader(raw), -5); err == nil {
+		t.Errorf("LoadSignatureLimit accepted a negative limit")
+	}
+}
+
+func TestSizeGuard(t *testing.T) {
+	data := make([]byte, 100)
+	for _, tc := range []struct {
+		left int64
+		err  error
+	}{
+		{math.MaxInt64, nil},
+		{100, nil},
+		{99, ErrSignatureTooLarge},
+		{0, ErrSignatureTooLarge},
+	} {
+		_, err := io.ReadAll(&sizeGuard{r: bytes.NewReader(data), left: tc.left})
+		if err != tc.err {
+			t.Errorf("limit %d: expected %v, got %v", tc.left, tc.err, err)
+		}
+	}
+}
+
+func TestFinish(t *testing.T) {
+	// An input that fails instead of returning io.EOF
+	input := io.MultiReader(bytes.NewReader(testdata.RandomData()), iotest.ErrReader(errors.New("input does not end")))
+
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, input)
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	// The first step reads all the data.
+	if _, _, err := siggen.Iterate(); err != nil {
+		t.Fatalf("Iterate failed: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := siggen.Finish(); err != nil {
+			t.Fatalf("Finish failed: %s", err)
+		}
+	}
+
+	// The rest of the output is drained by Read, without reading the input.
+	sig, err := io.ReadAll(siggen)
+	if err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+	if !bytes.Equal(sig, testdata.RandomDataSig()[0]) {
+		t.Errorf("signature of the finished job differs")
+	}
+
+	if err := siggen.Finish(); err != nil {
+		t.Errorf("Finish after completion failed: %s", err)
+	}
+	siggen.Close()
+	if err := siggen.Finish(); err != nil {
+		t.Errorf("Finish after Close failed: %s", err)
+	}
+}
+
+func TestBufferState(t *testing.T) {
+	// An input that does not end, so the job stops after its data.
+	input := io.MultiReader(bytes.NewReader(testdata.RandomData()), iotest.ErrReader(errors.New("input does not end")))
+
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, input)
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	if state := siggen.BufferState(); state != (BufferState{}) {
+		t.Errorf("new job has buffer state %+v", state)
+	}
+
+	produced, _, err := siggen.Iterate()
+	if err != nil {
+		t.Fatalf("Iterate failed: %s", err)
+	}
+	state := siggen.BufferState()
+	if state.EOFIn {
+		t.Errorf("end of input reported while the input continues")
+	}
+	if pos := siggen.InputPosition(); pos+int64(state.AvailIn) != int64(len(testdata.RandomData())) {
+		t.Errorf("%d bytes consumed and %d available, expected %d in total", pos, state.AvailIn, len(testdata.RandomData()))
+	}
+	if state.AvailOut != outbufSize-produced {
+		t.Errorf("%d bytes of output space left after producing %d, expected %d", state.AvailOut, produced, outbufSize-produced)
+	}
+
+	if err := siggen.Finish(); err != nil {
+		t.Fatalf("Finish failed: %s", err)
+	}
+	if _, err := io.Copy(io.Discard, siggen); err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+	if state := siggen.BufferState(); !state.EOFIn || state.AvailIn != 0 {
+		t.Errorf("finished job has buffer state %+v", state)
+	}
+
+	siggen.Close()
+	if state := siggen.BufferState(); state != (BufferState{}) {
+		t.Errorf("closed job has buffer state %+v", state)
+	}
+}
+
+func TestPatcherByteCounts(t *testing.T) {
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+
+	if _, err := io.Copy(io.Discard, patcher); err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+
+	// The delta consists of a copy of 4096 bytes between two literals.
+	if patcher.CopyBytes() != 4096 {
+		t.Errorf("expected 4096 copied bytes, got %d", patcher.CopyBytes())
+	}
+	if lit := int64(len(testdata.Mutation()) - 4096); patcher.LiteralBytes() != lit {
+		t.Errorf("expected %d literal bytes, got %d", lit, patcher.LiteralBytes())
+	}
+}
+
+func TestSignatureInputEOF(t *testing.T) {
+	data := testdata.RandomData()
+	inputs := map[string]io.Reader{
+		// returns the last data together with io.EOF
+		"data with EOF": iotest.DataErrReader(bytes.NewReader(data)),
+		// the same, but with reads ending in the middle of blocks
+		"half reads with EOF": iotest.DataErrReader(iotest.HalfReader(bytes.NewReader(data))),
+		// returns (0, io.EOF) after the data
+		"separate EOF": iotest.OneByteReader(bytes.NewReader(data)),
+		// the final, partial block comes with io.EOF
+		"partial block with EOF": iotest.DataErrReader(bytes.NewReader(data[:len(data)-100])),
+	}
+
+	for name, input := range inputs {
+		siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, input)
+		if err != nil {
+			t.Fatalf("NewSignatureGen failed: %s", err)
+		}
+
+		sig, err := io.ReadAll(siggen)
+		siggen.Close()
+		if err != nil {
+			t.Fatalf("%s: signature generation failed: %s", name, err)
+		}
+
+		expected := testdata.RandomDataSig()[0]
+		if name == "partial block with EOF" {
+			expected, _ = SignatureBytes(data[:len(data)-100], Config{BlockLen: 2048, StrongLen: 8, Hash: MD4})
+			if len(sig) != len(testdata.RandomDataSig()[0]) {
+				t.Errorf("%s: final partial block is missing", name)
+			}
+		}
+		if !bytes.Equal(sig, expected) {
+			t.Errorf("%s: signature differs from the golden one", name)
+		}
+	}
+}
+
+func TestReadFinalEOF(t *testing.T) {
+	newSigGen := func() *Job {
+		siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, bytes.NewReader(testdata.RandomData()))
+		if err != nil {
+			t.Fatalf("NewSignatureGen failed: %s", err)
+		}
+		return siggen
+	}
+	expected := testdata.RandomDataSig()[0]
+
+	siggen := newSigGen()
+	defer siggen.Close()
+	if err := iotest.TestReader(siggen, expected); err != nil {
+		t.Errorf("TestReader failed: %s", err)
+	}
+
+	// Reading everything at once ends with the data and io.EOF, later reads
+	// return io.EOF alone.
+	siggen2 := newSigGen()
+	defer siggen2.Close()
+
+	buf := make([]byte, 2*len(expected))
+	var got []byte
+	for {
+		n, err := siggen2.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			if n == 0 {
+				t.Errorf("final output was not returned together with io.EOF")
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("signature differs")
+	}
+	if n, err := siggen2.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after the end returned %d, %v", n, err)
+	}
+}
+
+func TestNewPatcherNil(t *testing.T) {
+	if _, err := NewPatcher(bytes.NewReader(testdata.Delta()), nil); err != ErrNilBasis {
+		t.Errorf("expected ErrNilBasis, got %v", err)
+	}
+	if _, err := NewPatcherSeeker(bytes.NewReader(testdata.Delta()), nil); err != ErrNilBasis {
+		t.Errorf("expected ErrNilBasis from NewPatcherSeeker, got %v", err)
+	}
+	if _, err := NewPatcher(nil, bytes.NewReader(testdata.RandomData())); err != ErrNilDelta {
+		t.Errorf("expected ErrNilDelta, got %v", err)
+	}
+}
+
+func TestNewPatcherLimit(t *testing.T) {
+	size := int64(len(testdata.Mutation()))
+
+	for _, limit := range []int64{5000, size} {
+		patcher, err := NewPatcherLimit(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()), limit)
+		if err != nil {
+			t.Fatalf("NewPatcherLimit failed: %s", err)
+		}
+
+		out := new(bytes.Buffer)
+		_, err = io.Copy(out, patcher)
+		patcher.Close()
+
+		if limit < size {
+			if err != ErrOutputLimitExceeded {
+				t.Errorf("limit %d: expected ErrOutputLimitExceeded, got %v", limit, err)
+			}
+			if int64(out.Len()) != limit {
+				t.Errorf("limit %d: got %d bytes of output", limit, out.Len())
+			}
+		} else if err != nil {
+			t.Errorf("limit %d: patching failed: %s", limit, err)
+		}
+	}
+}
+
+func TestConfigMagic(t *testing.T) {
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Magic: MD4SigMagic}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	sig, err := io.ReadAll(siggen)
+	siggen.Close()
+	if err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+	if !bytes.Equal(sig, testdata.RandomDataSig()[0]) {
+		t.Errorf("signature with MD4SigMagic differs from the MD4 signature")
+	}
+
+	for _, config := range []Config{
+		{Magic: DeltaMagic},
+		{Magic: 0x12345678},
+		{Magic: MD4SigMagic, Hash: BLAKE2},
+	} {
+		if siggen, err := NewSignatureGen(config, bytes.NewReader(nil)); err == nil {
+			siggen.Close()
+			t.Errorf("NewSignatureGen accepted magic %#x with hash %d", config.Magic, config.Hash)
+		}
+	}
+
+	sigObj, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sigObj.Close()
+	if delta, err := NewDeltaGenConfig(Config{Magic: MD4SigMagic}, sigObj, bytes.NewReader(nil)); err == nil {
+		delta.Close()
+		t.Errorf("NewDeltaGenConfig accepted a signature magic number")
+	}
+}
+
+func TestPatcherExpectedSize(t *testing.T) {
+	size := int64(len(testdata.Mutation()))
+
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+	if n, ok := patcher.ExpectedSize(); !ok || n != size {
+		t.Errorf("seekable delta: ExpectedSize returned %d, %t", n, ok)
+	}
+
+	// Hide the io.ReaderAt and io.Seeker methods.
+	delta := struct{ io.Reader }{bytes.NewReader(testdata.Delta())}
+	patcher2, err := NewPatcher(delta, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher2.Close()
+	if _, ok := patcher2.ExpectedSize(); ok {
+		t.Errorf("size of a plain reader delta is known before patching")
+	}
+	if _, err := io.Copy(io.Discard, patcher2); err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+	if n, ok := patcher2.ExpectedSize(); !ok || n != size {
+		t.Errorf("after patching: ExpectedSize returned %d, %t", n, ok)
+	}
+}
+
+// TestGoldenFiles checks that the linked librsync reproduces the golden files.
+// With -update, they are regenerated instead.
+func TestGoldenFiles(t *testing.T) {
+	if *update {
+		cmd := exec.Command("go", "generate")
+		cmd.Dir = "testdata"
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("regenerating golden files failed: %s\n%s", err, out)
+		}
+		t.Log("golden files regenerated, run the tests again to use them")
+		return
+	}
+
+	golden := testdata.RandomDataSig()
+	configs := []Config{{BlockLen: 2048, StrongLen: 8, Hash: MD4}}
+	if haveBLAKE2 {
+		configs = append(configs, Config{BlockLen: 2048, StrongLen: 32, Hash: BLAKE2})
+	}
+	for i, config := range configs {
+		siggen, err := NewSignatureGen(config, bytes.NewReader(testdata.RandomData()))
+		if err != nil {
+			t.Fatalf("NewSignatureGen failed: %s", err)
+		}
+		sig, err := io.ReadAll(siggen)
+		siggen.Close()
+		if err != nil {
+			t.Fatalf("signature generation failed: %s", err)
+		}
+		if !bytes.Equal(sig, golden[i]) {
+			t.Errorf("signature %d differs from the golden file, regenerate with -update if librsync changed its output", i)
+		}
+	}
+
+	delta, err := DeltaBytes(golden[0], testdata.Mutation())
+	if err != nil {
+		t.Fatalf("DeltaBytes failed: %s", err)
+	}
+	if !bytes.Equal(delta, testdata.Delta()) {
+		t.Errorf("delta differs from the golden file, regenerate with -update if librsync changed its output")
+	}
+}
+
+func TestSignatureBlockCount(t *testing.T) {
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+
+	if n, err := sig.BlockCount(); n != 4 || err != nil {
+		t.Errorf("BlockCount returned %d, %v; expected 4 blocks", n, err)
+	}
+
+	sig.Close()
+	if _, err := sig.BlockCount(); err != ErrSignatureClosed {
+		t.Errorf("closed signature: expected ErrSignatureClosed, got %v", err)
+	}
+}
+
+func TestReadOutputBeforeError(t *testing.T) {
+	// Replace the copy command after the first literal (of 2054 bytes) with a
+	// reserved opcode.
+	delta := append([]byte(nil), testdata.Delta()...)
+	delta[4+1+2+2054] = 0x60
+
+	patcher, err := NewPatcher(bytes.NewReader(delta), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+
+	var got []byte
+	buf := make([]byte, 100)
+	for {
+		n, err := patcher.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			if n > 0 {
+				t.Errorf("error returned together with output")
+			}
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected ErrCorrupt, got %v", err)
+			}
+			break
+		}
+	}
+
+	if !bytes.Equal(got, testdata.Mutation()[:2054]) {
+		t.Errorf("got %d bytes of output, expected the first literal of 2054 bytes", len(got))
+	}
+}
+
+func TestLoadSignatureWithoutHashTable(t *testing.T) {
+	sig, err := LoadSignatureWithOptions(bytes.NewReader(testdata.RandomDataSig()[0]), LoadSignatureOptions{})
+	if err != nil {
+		t.Fatalf("LoadSignatureWithOptions failed: %s", err)
+	}
+	defer sig.Close()
+
+	if sig.BlockLen() != 2048 {
+		t.Errorf("unexpected block length %d", sig.BlockLen())
+	}
+	if deltagen, err := NewDeltaGen(sig, bytes.NewReader(testdata.Mutation())); !errors.Is(err, ErrHashTableNotBuilt) {
+		if err == nil {
+			deltagen.Close()
+		}
+		t.Fatalf("expected ErrHashTableNotBuilt for a signature without hash table, got %v", err)
+	}
+
+	if err := sig.BuildHashTable(); err != nil {
+		t.Fatalf("BuildHashTable failed: %s", err)
+	}
+	deltagen, err := NewDeltaGen(sig, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("NewDeltaGen failed: %s", err)
+	}
+	defer deltagen.Close()
+
+	delta, err := io.ReadAll(deltagen)
+	if err != nil {
+		t.Fatalf("delta generation failed: %s", err)
+	}
+	if !bytes.Equal(delta, testdata.Delta()) {
+		t.Errorf("delta differs from the expected one")
+	}
+}
+
+func TestConfigRollingChecksum(t *testing.T) {
+	if _, err := NewSignatureGen(Config{RollingChecksum: Rollsum, Hash: RabinKarpMD4}, bytes.NewReader(nil)); !errors.Is(err, ErrRollsumMismatch) {
+		t.Errorf("conflicting Hash: expected ErrRollsumMismatch, got %v", err)
+	}
+
+	config := Config{RollingChecksum: RabinKarp, CompatMD4: true}
+	if err := config.setup(nil); err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	if config.Hash != RabinKarpMD4 {
+		t.Errorf("expected RabinKarpMD4, got hash %d", config.Hash)
+	}
+
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	_, err = NewDeltaGenConfig(Config{RollingChecksum: RabinKarp}, sig, bytes.NewReader(nil))
+	if !errors.Is(err, ErrRollsumMismatch) {
+		t.Errorf("expected ErrRollsumMismatch for a Rollsum signature, got %v", err)
+	}
+	deltagen, err := NewDeltaGenConfig(Config{RollingChecksum: Rollsum}, sig, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("NewDeltaGenConfig failed: %s", err)
+	}
+	deltagen.Close()
+}
+
+func TestPatcherOnCopy(t *testing.T) {
+	var copied int64
+	config := Config{OnCopy: func(pos, length int64) {
+		if pos < 2048 || pos+length > 2048+4096 {
+			t.Errorf("copy of %d bytes at %d outside of the copied range", length, pos)
+		}
+		copied += length
+	}}
+
+	patcher, err := NewPatcherConfig(config, bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcherConfig failed: %s", err)
+	}
+	defer patcher.Close()
+
+	if _, err := io.Copy(io.Discard, patcher); err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+	if copied != 4096 {
+		t.Errorf("OnCopy reported %d bytes, expected 4096", copied)
+	}
+}
+
+func TestJobSetReadDeadline(t *testing.T) {
+	// An input without SetReadDeadline, the deadline is checked between reads.
+	siggen, err := NewSignatureGen(Config{}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+	siggen.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := io.ReadAll(siggen); err != ErrReadTimeout {
+		t.Errorf("expired deadline: expected ErrReadTimeout, got %v", err)
+	}
+
+	// A blocking input with SetReadDeadline.
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	siggen2, err := NewSignatureGen(Config{}, conn)
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen2.Close()
+	if err := siggen2.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %s", err)
+	}
+	if _, err := io.ReadAll(siggen2); err != ErrReadTimeout {
+		t.Errorf("blocked read: expected ErrReadTimeout, got %v", err)
+	}
+}
+
+func TestJobReadFrame(t *testing.T) {
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	defer patcher.Close()
+
+	if _, err := patcher.ReadFrame(0); err == nil {
+		t.Errorf("ReadFrame accepted a frame size of 0")
+	}
+
+	newfile := new(bytes.Buffer)
+	for {
+		frame, err := patcher.ReadFrame(1000)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %s", err)
+		}
+		if len(frame) == 0 || len(frame) > 1000 {
+			t.Fatalf("got a frame of %d bytes", len(frame))
+		}
+		newfile.Write(frame)
+	}
+
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Errorf("patch result and mutation are not equal")
+	}
+}
+
+func TestJobPositions(t *testing.T) {
+	siggen, err := NewSignatureGen(Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	defer siggen.Close()
+
+	if siggen.InputPosition() != 0 || siggen.OutputPosition() != 0 {
+		t.Errorf("new job reports positions %d, %d", siggen.InputPosition(), siggen.OutputPosition())
+	}
+
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(siggen, buf); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if siggen.OutputPosition() != 10 {
+		t.Errorf("expected output position 10, got %d", siggen.OutputPosition())
+	}
+
+	if _, err := io.Copy(io.Discard, siggen); err != nil {
+		t.Fatalf("signature generation failed: %s", err)
+	}
+	if in := siggen.InputPosition(); in != int64(len(testdata.RandomData())) {
+		t.Errorf("expected input position %d, got %d", len(testdata.RandomData()), in)
+	}
+	if out := siggen.OutputPosition(); out != int64(len(testdata.RandomDataSig()[0])) {
+		t.Errorf("expected output position %d, got %d", len(testdata.RandomDataSig()[0]), out)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	siggen, err := NewSignatureGen(Config{}, bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewSignatureGen failed: %s", err)
+	}
+	if err := siggen.Close(); err != nil {
+		t.Errorf("first Close failed: %s", err)
+	}
+	if err := siggen.Close(); err != ErrAlreadyClosed {
+		t.Errorf("second Close: expected ErrAlreadyClosed, got %v", err)
+	}
+}
+
+func TestPatcherCloseTwice(t *testing.T) {
+	patcher, err := NewPatcher(bytes.NewReader(testdata.Delta()), bytes.NewReader(testdata.RandomData()))
+	if err != nil {
+		t.Fatalf("NewPatcher failed: %s", err)
+	}
+	// The usual pattern of a deferred Close and an explicit one on the
+	// success path must not free anything twice.
+	defer patcher.Close()
+
+	if _, err := io.Copy(io.Discard, patcher); err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+	if err := patcher.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	if patcher.inbuf != nil || patcher.outbufOrig != nil || patcher.rsbufs != nil || patcher.buf != nil {
+		t.Errorf("Close left buffers behind")
+	}
+	if err := patcher.Close(); err != ErrAlreadyClosed {
+		t.Errorf("second Close: expected ErrAlreadyClosed, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package librsync
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Delta generation against several basis files at once. The bases are laid out
+// one after another in a virtual basis, each starting at a block boundary. A
+// signature of the virtual basis is the concatenation of the bases' block
+// lists, and MultiBasis maps offsets in the virtual basis back to the bases.
+
+var ErrSignatureMismatch = errors.New("Signatures have different parameters")
+
+// LoadSignatures loads the signatures of several basis files as a single
+// signature, so a delta against it can copy from any of the bases. All
+// signatures must have the same hash algorithm, block length and strong sum
+// length. Patch such a delta with a MultiBasis of the bases, in the same order.
+//
+// The inputs are streamed into the signature one after another, so they are
+// not held in memory on top of it.
+func LoadSignatures(inputs ...io.Reader) (Signature, error) {
+	concat, err := concatSignatures(inputs...)
+	if err != nil {
+		return Signature{}, err
+	}
+	return LoadSignature(concat)
+}
+
+// concatSignatures concatenates the block lists of serialized signatures,
+// keeping only the header of the first one. The other headers are checked
+// against it when their input is reached.
+func concatSignatures(inputs ...io.Reader) (io.Reader, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("No signatures given")
+	}
+
+	head := make([]byte, sigHeaderLen)
+	if _, err := io.ReadFull(inputs[0], head); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInputEnded
+		}
+		return nil, err
+	}
+	first, _ := parseSigHeader(head)
+	if err := first.check(); err != nil {
+		return nil, err
+	}
+
+	parts := []io.Reader{bytes.NewReader(head)}
+	for i, input := range inputs {
+		parts = append(parts, &sigPart{r: input, header: first, checkHeader: i > 0})
+	}
+	return io.MultiReader(parts...), nil
+}
+
+// sigPart passes on the block entries of one of the signatures concatenated
+// by concatSignatures, after checking and dropping its header if checkHeader
+// is set. Each part has to consist of whole entries.
+type sigPart struct {
+	r           io.Reader
+	header      sigHeader // of the first signature
+	checkHeader bool
+	n           int64 // entry bytes passed on
+}
+
+func (p *sigPart) Read(b []byte) (int, error) {
+	if p.checkHeader {
+		var head [sigHeaderLen]byte
+		if _, err := io.ReadFull(p.r, head[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = ErrInputEnded
+			}
+			return 0, err
+		}
+		if h, _ := parseSigHeader(head[:]); h != p.header {
+			return 0, ErrSignatureMismatch
+		}
+		p.checkHeader = false
+	}
+
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if err == io.EOF && p.n%int64(p.header.entryLen()) != 0 {
+		err = ErrCorrupt
+	}
+	return n, err
+}
+
+// MultiBasis is the virtual basis of a signature loaded with LoadSignatures.
+// It implements io.ReaderAt for use as the basis of a Patcher.
+type MultiBasis struct {
+	parts []basisPart
+}
+
+type basisPart struct {
+	start int64 // offset in the virtual basis
+	r     *io.SectionReader
+}
+
+// NewMultiBasis creates the virtual basis of the given basis files, which must
+// be in the same order as their signatures were passed to LoadSignatures.
+// blockLen is the block length of the signatures.
+func NewMultiBasis(blockLen uint, bases ...*io.SectionReader) *MultiBasis {
+	mb := &MultiBasis{parts: make([]basisPart, 0, len(bases))}
+
+	var start int64
+	for _, r := range bases {
+		mb.parts = append(mb.parts, basisPart{start, r})
+
+		// The next basis starts at the next block boundary.
+		blocks := (r.Size() + int64(blockLen) - 1) / int64(blockLen)
+		start += blocks * int64(blockLen)
+	}
+	return mb
+}
+
+// ReadAt reads from the basis that covers off. Reads end at the end of a
+// basis, unless the next one follows immediately.
+func (mb *MultiBasis) ReadAt(p []byte, off int64) (n int, err error) {
+	for len(p) > 0 {
+		i := sort.Search(len(mb.parts), func(i int) bool {
+			return mb.parts[i].start > off
+		}) - 1
+		if i < 0 {
+			return n, io.EOF
+		}
+		part := mb.parts[i]
+
+		m, err := part.r.ReadAt(p, off-part.start)
+		n += m
+		p = p[m:]
+		off += int64(m)
+
+		if err != io.EOF {
+			return n, err
+		}
+		if len(p) == 0 || i+1 == len(mb.parts) || mb.parts[i+1].start != off {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// NewDeltaGenWithDictionary is like NewDeltaGen, but the delta can also copy
+// from dict, a dictionary of data that is common to many files, like
+// boilerplate headers. dict is signed with the parameters of sig, and its
+// blocks are added to those of sig as if it followed the basis.
+//
+// Patch such a delta with NewMultiBasis(sig.BlockLen(), basis, dict) as the
+// basis. The combined signature is freed when the job is closed. sig must be
+// loaded with LoadSignatureOptions.KeepSerialized, ErrNotSerialized is
+// returned otherwise.
+func NewDeltaGenWithDictionary(sig Signature, dict io.Reader, newfile io.Reader) (*Job, error) {
+	if sig.closed() {
+		return nil, ErrSignatureClosed
+	}
+	if sig.raw == nil {
+		return nil, ErrNotSerialized
+	}
+
+	config := Config{BlockLen: sig.BlockLen(), StrongLen: sig.StrongLen(), Hash: sig.HashAlgorithm()}
+	siggen, err := NewSignatureGen(config, dict)
+	if err != nil {
+		return nil, err
+	}
+	defer siggen.Close()
+
+	concat, err := concatSignatures(bytes.NewReader(sig.raw), siggen)
+	if err != nil {
+		return nil, err
+	}
+	combined, err := LoadSignature(concat)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := NewDeltaGen(combined, newfile)
+	if err != nil {
+		combined.Close()
+		return nil, err
+	}
+	job.closers = append(job.closers, combined)
+	return job, nil
+}
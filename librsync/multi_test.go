@@ -0,0 +1,153 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestMultiBasis(t *testing.T) {
+	a, b := testdata.RandomData(), testdata.Mutation()
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+
+	sigA, err := SignatureBytes(a, config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+	sigB, err := SignatureBytes(b, config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+
+	sig, err := LoadSignatures(bytes.NewReader(sigA), bytes.NewReader(sigB))
+	if err != nil {
+		t.Fatalf("LoadSignatures failed: %s", err)
+	}
+	defer sig.Close()
+
+	// Data from both bases, the one that is not block aligned first.
+	newfile := append(append([]byte(nil), b...), a...)
+
+	deltagen, err := NewDeltaGen(sig, bytes.NewReader(newfile))
+	if err != nil {
+		t.Fatalf("NewDeltaGen failed: %s", err)
+	}
+	defer deltagen.Close()
+
+	delta, err := io.ReadAll(deltagen)
+	if err != nil {
+		t.Fatalf("delta generation failed: %s", err)
+	}
+	if len(delta) > 100 {
+		t.Errorf("delta of data from the bases is %d bytes long", len(delta))
+	}
+
+	basis := NewMultiBasis(2048, io.NewSectionReader(bytes.NewReader(a), 0, int64(len(a))), io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))))
+	result := new(bytes.Buffer)
+	if err := Patch(basis, bytes.NewReader(delta), result); err != nil {
+		t.Fatalf("Patch failed: %s", err)
+	}
+	if !bytes.Equal(result.Bytes(), newfile) {
+		t.Errorf("patch result and new file are not equal")
+	}
+}
+
+func TestConcatSignatures(t *testing.T) {
+	head := []byte{0x72, 0x73, 0x01, 0x36, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x02}
+	a := append(append([]byte(nil), head...), 0, 0, 0, 1, 0xaa, 0xaa)
+	b := append(append([]byte(nil), head...), 0, 0, 0, 2, 0xbb, 0xbb, 0, 0, 0, 3, 0xcc, 0xcc)
+
+	concat, err := concatSignatures(bytes.NewReader(a), bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("concatSignatures failed: %s", err)
+	}
+	got, err := readChunked(concat, 5)
+	if err != nil {
+		t.Fatalf("reading failed: %s", err)
+	}
+	if expected := append(append([]byte(nil), a...), b[sigHeaderLen:]...); !bytes.Equal(got, expected) {
+		t.Errorf("expected %x, got %x", expected, got)
+	}
+
+	other := append(append([]byte(nil), b...), 0)
+	other[11] = 3 // different strong sum length
+	concat, _ = concatSignatures(bytes.NewReader(a), bytes.NewReader(other))
+	if _, err := io.ReadAll(concat); err != ErrSignatureMismatch {
+		t.Errorf("different parameters: expected ErrSignatureMismatch, got %v", err)
+	}
+
+	concat, _ = concatSignatures(bytes.NewReader(a[:len(a)-1]), bytes.NewReader(b))
+	if _, err := io.ReadAll(concat); err != ErrCorrupt {
+		t.Errorf("partial entry: expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err := concatSignatures(bytes.NewReader(head[:5])); err != ErrInputEnded {
+		t.Errorf("short header: expected ErrInputEnded, got %v", err)
+	}
+}
+
+func TestMultiBasisReadAt(t *testing.T) {
+	basis := NewMultiBasis(4,
+		io.NewSectionReader(bytes.NewReader([]byte("abcdef")), 0, 6),
+		io.NewSectionReader(bytes.NewReader([]byte("ghij")), 0, 4),
+		io.NewSectionReader(bytes.NewReader([]byte("kl")), 0, 2),
+	)
+
+	for _, tc := range []struct {
+		off  int64
+		len  int
+		data string
+		err  error
+	}{
+		{0, 6, "abcdef", nil},
+		{4, 4, "ef", io.EOF}, // the first basis is padded to 8 bytes
+		{8, 4, "ghij", nil},
+		{10, 4, "ijkl", nil}, // the second basis ends at a block boundary
+		{12, 4, "kl", io.EOF},
+	} {
+		buf := make([]byte, tc.len)
+		n, err := basis.ReadAt(buf, tc.off)
+		if string(buf[:n]) != tc.data || err != tc.err {
+			t.Errorf("ReadAt(%d, %d): expected %q, %v; got %q, %v", tc.len, tc.off, tc.data, tc.err, buf[:n], err)
+		}
+	}
+}
+
+func TestNewDeltaGenWithDictionary(t *testing.T) {
+	data := testdata.RandomData()
+	dict := make([]byte, 6000)
+	rand.New(rand.NewSource(2)).Read(dict)
+
+	sig, err := LoadSignatureWithOptions(bytes.NewReader(testdata.RandomDataSig()[0]), LoadSignatureOptions{BuildHashTable: true, KeepSerialized: true})
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	// Data from the dictionary and from the basis.
+	newfile := append(append([]byte(nil), dict[:4096]...), data[2048:4096]...)
+
+	deltagen, err := NewDeltaGenWithDictionary(sig, bytes.NewReader(dict), bytes.NewReader(newfile))
+	if err != nil {
+		t.Fatalf("NewDeltaGenWithDictionary failed: %s", err)
+	}
+	delta, err := io.ReadAll(deltagen)
+	deltagen.Close()
+	if err != nil {
+		t.Fatalf("delta generation failed: %s", err)
+	}
+	if len(delta) > 100 {
+		t.Errorf("delta of data from the dictionary and basis is %d bytes long", len(delta))
+	}
+
+	basis := NewMultiBasis(sig.BlockLen(), io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), io.NewSectionReader(bytes.NewReader(dict), 0, int64(len(dict))))
+	result := new(bytes.Buffer)
+	if err := Patch(basis, bytes.NewReader(delta), result); err != nil {
+		t.Fatalf("Patch failed: %s", err)
+	}
+	if !bytes.Equal(result.Bytes(), newfile) {
+		t.Errorf("patch result and new file are not equal")
+	}
+}
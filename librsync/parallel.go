@@ -0,0 +1,89 @@
+package librsync
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// NewParallelSignatureGen generates the signature of the size bytes of basis
+// with up to workers concurrent jobs, runtime.NumCPU() if workers <= 0. The
+// basis is split into segments on block boundaries, whose signatures are
+// concatenated in order, so the result is identical to that of
+// NewSignatureGen.
+//
+// The signature is kept in memory until it is read. config.Tee and
+// config.Progress are not supported.
+func NewParallelSignatureGen(config Config, basis io.ReaderAt, size int64, workers int) (io.Reader, error) {
+	if config.Tee != nil || config.Progress != nil {
+		return nil, errors.New("Tee and Progress are not supported by NewParallelSignatureGen")
+	}
+	if size < 0 {
+		return nil, errors.New("Negative basis size")
+	}
+	if err := config.setup(io.NewSectionReader(basis, 0, size)); err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	blockLen := int64(config.BlockLen)
+	blocks := (size + blockLen - 1) / blockLen
+	segBlocks := (blocks + int64(workers) - 1) / int64(workers)
+	if segBlocks == 0 {
+		segBlocks = 1
+	}
+	segLen := segBlocks * blockLen
+
+	segments := int((size + segLen - 1) / segLen)
+	if segments == 0 {
+		segments = 1 // an empty basis still has a signature header
+	}
+
+	raws := make([][]byte, segments)
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+	for i := range raws {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// The last segment ends at size, even if basis holds more.
+			off := int64(i) * segLen
+			n := segLen
+			if n > size-off {
+				n = size - off
+			}
+			raws[i], errs[i] = segmentSignature(config, io.NewSectionReader(basis, off, n))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	segmentSigs := make([]io.Reader, len(raws))
+	for i, raw := range raws {
+		segmentSigs[i] = bytes.NewReader(raw)
+	}
+	return concatSignatures(segmentSigs...)
+}
+
+func segmentSignature(config Config, segment io.Reader) ([]byte, error) {
+	siggen, err := NewSignatureGen(config, segment)
+	if err != nil {
+		return nil, err
+	}
+	defer siggen.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(siggen); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
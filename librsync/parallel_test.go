@@ -0,0 +1,66 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+)
+
+func TestParallelSignatureGen(t *testing.T) {
+	data := testdata.RandomData()
+	basis := bytes.NewReader(data)
+
+	for _, blockLen := range []uint{1000, 2048} {
+		config := Config{BlockLen: blockLen, StrongLen: 8, Hash: MD4}
+
+		siggen, err := NewSignatureGen(config, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewSignatureGen failed: %s", err)
+		}
+		serial, err := io.ReadAll(siggen)
+		siggen.Close()
+		if err != nil {
+			t.Fatalf("signature generation failed: %s", err)
+		}
+
+		for _, workers := range []int{1, 3, 4, 100} {
+			r, err := NewParallelSignatureGen(config, basis, int64(len(data)), workers)
+			if err != nil {
+				t.Fatalf("NewParallelSignatureGen failed: %s", err)
+			}
+			parallel, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("reading parallel signature failed: %s", err)
+			}
+			if !bytes.Equal(parallel, serial) {
+				t.Errorf("block length %d, %d workers: parallel signature differs from serial one", blockLen, workers)
+			}
+		}
+	}
+}
+
+func TestParallelSignatureGenLongerBasis(t *testing.T) {
+	data := testdata.RandomData()
+	config := Config{BlockLen: 1000, StrongLen: 8, Hash: MD4}
+
+	// Only the first 5500 bytes are signed, ending within a block.
+	serial, err := SignatureBytes(data[:5500], config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+
+	for _, workers := range []int{1, 3} {
+		r, err := NewParallelSignatureGen(config, bytes.NewReader(data), 5500, workers)
+		if err != nil {
+			t.Fatalf("NewParallelSignatureGen failed: %s", err)
+		}
+		parallel, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading parallel signature failed: %s", err)
+		}
+		if !bytes.Equal(parallel, serial) {
+			t.Errorf("%d workers: signature covers more than size bytes", workers)
+		}
+	}
+}
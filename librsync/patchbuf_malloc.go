@@ -0,0 +1,32 @@
+//go:build patchmalloc
+
+package librsync
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// patchMem is the memory behind the patch callback buffer, allocated with
+// malloc. Without the patchmalloc tag, pinned Go memory is used instead, see
+// patchbuf_pinned.go.
+type patchMem struct {
+	p unsafe.Pointer
+}
+
+func (m *patchMem) alloc(size int) unsafe.Pointer {
+	m.free()
+	m.p = C.malloc(C.size_t(size))
+	return m.p
+}
+
+func (m *patchMem) free() {
+	if m.p != nil {
+		C.free(m.p)
+		m.p = nil
+	}
+}
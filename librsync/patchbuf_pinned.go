@@ -0,0 +1,38 @@
+//go:build !patchmalloc
+
+package librsync
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// patchMem is the memory behind the patch callback buffer. It is Go memory,
+// and librsync copies the data out of it before the callback returns, so it
+// never holds on to the buffer. It is pinned only because its address is
+// passed to C through the callback's buf argument, which cgo does not allow
+// for unpinned Go memory. The pin is released by free, which Patcher.Close
+// calls. An unclosed Patcher is never
+// collected, as the patcher store references it, so the Pinner can not leak
+// a pin into the garbage collector.
+//
+// Build with the patchmalloc tag to use C memory instead.
+type patchMem struct {
+	mem    []byte
+	pinner runtime.Pinner
+}
+
+func (m *patchMem) alloc(size int) unsafe.Pointer {
+	m.free()
+	if size < 1 {
+		size = 1
+	}
+	m.mem = make([]byte, size)
+	m.pinner.Pin(&m.mem[0])
+	return unsafe.Pointer(&m.mem[0])
+}
+
+func (m *patchMem) free() {
+	m.pinner.Unpin()
+	m.mem = nil
+}
@@ -2,8 +2,18 @@ package librsync
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
+// patcherIDs counts up to give every Patcher a unique id. Unlike addresses of C
+// memory, these are never reused.
+var patcherIDs atomic.Uintptr
+
+// newPatcherID returns a new id for storePatcher.
+func newPatcherID() uintptr {
+	return patcherIDs.Add(1)
+}
+
 // pointerMap holds Go *Patcher objects to pass to C.
 // Don't touch this data structure, instead use the storePatcher, getPatcher,
 // and dropPatcher functions.
@@ -14,16 +24,16 @@ var patcherStore = struct {
 	store: make(map[uintptr]*Patcher),
 }
 
-// storePatcher stores the value and returns a reference to it, for use in a CGo
-// call. Use the same reference for dropPatcher. C callbacks can use getPatcher
-// to get the original value.
+// storePatcher stores the value under id, which comes from newPatcherID and is
+// passed to C instead of a Go pointer. Use the same id for dropPatcher. C
+// callbacks can use getPatcher to get the original value.
 func storePatcher(patcher *Patcher, id uintptr) {
 	patcherStore.lock.Lock()
 	defer patcherStore.lock.Unlock()
 
 	if _, ok := patcherStore.store[id]; ok {
 		// Just to be on the safe side.
-		panic("pointer already stored")
+		panic("id already stored")
 	}
 	patcherStore.store[id] = patcher
 }
@@ -44,7 +54,7 @@ func dropPatcher(id uintptr) {
 	defer patcherStore.lock.Unlock()
 
 	if _, ok := patcherStore.store[id]; !ok {
-		panic("pointer not stored")
+		panic("id not stored")
 	}
 
 	delete(patcherStore.store, id)
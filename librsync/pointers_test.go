@@ -0,0 +1,24 @@
+package librsync
+
+import (
+	"testing"
+)
+
+func TestPatcherStore(t *testing.T) {
+	a, b := &Patcher{id: newPatcherID()}, &Patcher{id: newPatcherID()}
+	if a.id == b.id {
+		t.Fatalf("patchers got the same id %d", a.id)
+	}
+
+	storePatcher(a, a.id)
+	storePatcher(b, b.id)
+	if getPatcher(a.id) != a || getPatcher(b.id) != b {
+		t.Errorf("getPatcher returned the wrong patcher")
+	}
+
+	dropPatcher(a.id)
+	if getPatcher(a.id) != nil {
+		t.Errorf("dropped patcher is still stored")
+	}
+	dropPatcher(b.id)
+}
@@ -0,0 +1,117 @@
+package librsync
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// seekerReaderAt implements io.ReaderAt on top of an io.ReadSeeker by seeking
+// before every read. Reads are serialized, but the position of the underlying
+// ReadSeeker is changed, so it must not be used by anything else meanwhile.
+type seekerReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (s *seekerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(s.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ReaderAtFromSeeker returns an io.ReaderAt reading from rs by seeking before
+// every read. Concurrent calls of ReadAt are correct, but do not run in
+// parallel. The position of rs is changed, so rs must not be used by anything
+// else while the ReaderAt is in use.
+func ReaderAtFromSeeker(rs io.ReadSeeker) io.ReaderAt {
+	return &seekerReaderAt{rs: rs}
+}
+
+// NewPatcherSeeker is like NewPatcher, but reads the basis from an
+// io.ReadSeeker, seeking before every read.
+//
+// The patcher moves the position of basis as it pleases, so basis must not be
+// used by anything else (especially not concurrently) until the patcher is
+// closed.
+func NewPatcherSeeker(delta io.Reader, basis io.ReadSeeker) (*Patcher, error) {
+	if basis == nil {
+		return nil, ErrNilBasis
+	}
+	return NewPatcher(delta, ReaderAtFromSeeker(basis))
+}
+
+var ErrNonSequentialDelta = errors.New("Delta copies from an earlier position of a streamed basis")
+
+// streamingBasis implements io.ReaderAt on top of an io.Reader for reads at
+// offsets that never decrease. The data from the offset of the last read on is
+// kept, so following reads may overlap it.
+type streamingBasis struct {
+	r     io.Reader
+	start int64  // offset of buf in the basis
+	buf   []byte // data from start on
+	err   error  // sticky error of r
+}
+
+func (s *streamingBasis) ReadAt(p []byte, off int64) (int, error) {
+	if off < s.start {
+		return 0, ErrNonSequentialDelta
+	}
+
+	if skip := off - s.start; skip < int64(len(s.buf)) {
+		s.buf = s.buf[skip:]
+	} else {
+		if s.err == nil {
+			if _, err := io.CopyN(io.Discard, s.r, skip-int64(len(s.buf))); err != nil {
+				s.err = err
+			}
+		}
+		s.buf = s.buf[:0]
+	}
+	s.start = off
+
+	if len(s.buf) < len(p) && s.err == nil {
+		if cap(s.buf) < len(p) {
+			buf := make([]byte, len(s.buf), len(p))
+			copy(buf, s.buf)
+			s.buf = buf
+		}
+
+		n, err := io.ReadFull(s.r, s.buf[len(s.buf):len(p)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		s.err = err
+	}
+
+	n := copy(p, s.buf)
+	if n < len(p) {
+		return n, s.err
+	}
+	return n, nil
+}
+
+// NewStreamingPatcher is like NewPatcher, but reads the basis from an
+// io.Reader in one pass, e.g. from a pipe or a network connection. This only
+// works for deltas whose copy commands never go back to an earlier position of
+// the basis, which is typical for deltas of files that were modified in
+// place. Only the data of the current copy command is buffered.
+//
+// If the delta refers back, patching fails with an error wrapping
+// ErrNonSequentialDelta.
+func NewStreamingPatcher(delta io.Reader, basis io.Reader) (*Patcher, error) {
+	if basis == nil {
+		return nil, ErrNilBasis
+	}
+	return NewPatcher(delta, &streamingBasis{r: basis})
+}
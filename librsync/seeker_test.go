@@ -0,0 +1,136 @@
+package librsync
+
+import (
+	"bytes"
+	"errors"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"sync"
+	"testing"
+)
+
+// onlyReadSeeker hides all methods but Read and Seek.
+type onlyReadSeeker struct {
+	io.ReadSeeker
+}
+
+func TestReaderAtFromSeeker(t *testing.T) {
+	data := testdata.RandomData()
+	r := ReaderAtFromSeeker(onlyReadSeeker{bytes.NewReader(data)})
+
+	buf := make([]byte, 100)
+	if n, err := r.ReadAt(buf, 1000); n != 100 || err != nil {
+		t.Fatalf("ReadAt returned %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, data[1000:1100]) {
+		t.Errorf("ReadAt read wrong data")
+	}
+
+	if n, err := r.ReadAt(buf, int64(len(data))-10); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt at the end returned %d, %v; expected 10, EOF", n, err)
+	}
+}
+
+func TestNewPatcherSeeker(t *testing.T) {
+	patcher, err := NewPatcherSeeker(bytes.NewReader(testdata.Delta()), onlyReadSeeker{bytes.NewReader(testdata.RandomData())})
+	if err != nil {
+		t.Fatalf("NewPatcherSeeker failed: %s", err)
+	}
+	defer patcher.Close()
+
+	newfile := new(bytes.Buffer)
+	if _, err := io.Copy(newfile, patcher); err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Fatalf("patch result and mutation are not equal")
+	}
+}
+
+func TestReaderAtFromSeekerConcurrent(t *testing.T) {
+	data := testdata.RandomData()
+	r := ReaderAtFromSeeker(onlyReadSeeker{bytes.NewReader(data)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+
+			buf := make([]byte, 100)
+			for j := 0; j < 100; j++ {
+				if _, err := r.ReadAt(buf, off); err != nil {
+					t.Errorf("ReadAt failed: %s", err)
+					return
+				}
+				if !bytes.Equal(buf, data[off:off+100]) {
+					t.Errorf("ReadAt at %d read wrong data", off)
+					return
+				}
+			}
+		}(int64(i * 1000))
+	}
+	wg.Wait()
+}
+
+func TestStreamingBasis(t *testing.T) {
+	data := testdata.RandomData()
+	basis := &streamingBasis{r: onlyReadSeeker{bytes.NewReader(data)}}
+
+	buf := make([]byte, 100)
+	for _, off := range []int64{0, 50, 1000, 1000, 1099} {
+		if n, err := basis.ReadAt(buf, off); n != 100 || err != nil {
+			t.Fatalf("ReadAt at %d returned %d, %v", off, n, err)
+		}
+		if !bytes.Equal(buf, data[off:off+100]) {
+			t.Errorf("ReadAt at %d read wrong data", off)
+		}
+	}
+
+	if _, err := basis.ReadAt(buf, 1000); err != ErrNonSequentialDelta {
+		t.Errorf("backward read: expected ErrNonSequentialDelta, got %v", err)
+	}
+
+	if n, err := basis.ReadAt(buf, int64(len(data))-10); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt at the end returned %d, %v; expected 10, EOF", n, err)
+	}
+}
+
+func TestNewStreamingPatcher(t *testing.T) {
+	patcher, err := NewStreamingPatcher(bytes.NewReader(testdata.Delta()), onlyReadSeeker{bytes.NewReader(testdata.RandomData())})
+	if err != nil {
+		t.Fatalf("NewStreamingPatcher failed: %s", err)
+	}
+	newfile := new(bytes.Buffer)
+	_, err = io.Copy(newfile, patcher)
+	patcher.Close()
+	if err != nil {
+		t.Fatalf("patching failed: %s", err)
+	}
+	if !bytes.Equal(newfile.Bytes(), testdata.Mutation()) {
+		t.Errorf("patched data differs from the mutation")
+	}
+
+	backward := new(bytes.Buffer)
+	enc := newDeltaEncoder(backward)
+	if err := enc.copy(4096, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.copy(0, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.end(); err != nil {
+		t.Fatal(err)
+	}
+
+	patcher, err = NewStreamingPatcher(backward, onlyReadSeeker{bytes.NewReader(testdata.RandomData())})
+	if err != nil {
+		t.Fatalf("NewStreamingPatcher failed: %s", err)
+	}
+	_, err = io.Copy(io.Discard, patcher)
+	patcher.Close()
+	if !errors.Is(err, ErrNonSequentialDelta) {
+		t.Errorf("expected ErrNonSequentialDelta, got %v", err)
+	}
+}
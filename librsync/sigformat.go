@@ -0,0 +1,223 @@
+package librsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The signature format: A header of the magic number, the block length and
+// the strong sum length, followed by the weak sum and the strong sum of every
+// block. All integers are 32 bit big endian.
+
+const sigHeaderLen = 12
+
+type sigHeader struct {
+	magic     uint32
+	blockLen  uint32
+	strongLen uint32
+}
+
+func parseSigHeader(b []byte) (h sigHeader, err error) {
+	if len(b) < sigHeaderLen {
+		return h, ErrInputEnded
+	}
+
+	h.magic = binary.BigEndian.Uint32(b[0:])
+	h.blockLen = binary.BigEndian.Uint32(b[4:])
+	h.strongLen = binary.BigEndian.Uint32(b[8:])
+	return
+}
+
+// check rejects headers of unknown formats and with impossible strong sum
+// lengths, before the entry length is used to size anything.
+func (h sigHeader) check() error {
+	hash := hashByMagic(h.magic)
+	if hash == DefaultHash {
+		return ErrBadMagic
+	}
+	if h.strongLen == 0 || uint(h.strongLen) > hash.maxStrongLen() {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// entryLen returns the length of a single block entry.
+func (h sigHeader) entryLen() int {
+	return 4 + int(h.strongLen)
+}
+
+// sigBlocks splits a serialized signature into its header and the block
+// entries.
+func sigBlocks(raw []byte) (h sigHeader, blocks []byte, err error) {
+	if h, err = parseSigHeader(raw); err != nil {
+		return
+	}
+
+	blocks = raw[sigHeaderLen:]
+	if len(blocks)%h.entryLen() != 0 {
+		err = ErrCorrupt
+	}
+	return
+}
+
+// CollisionStats describes how the weak sums of a signature's blocks are
+// distributed over the buckets of the hash table used for delta generation.
+// Blocks sharing a bucket have to be told apart by their strong sums, and with
+// short strong sums, false matches become more likely. Many colliding blocks
+// suggest that the block length is too small for the size of the file.
+type CollisionStats struct {
+	Blocks           int // number of blocks
+	Buckets          int // number of distinct weak sums
+	CollidingBuckets int // buckets holding more than one block
+	CollidingBlocks  int // blocks in buckets with more than one block
+	LargestBucket    int // number of blocks in the fullest bucket
+}
+
+// collisionCounter counts the weak sums of a serialized signature while it is
+// loaded, see LoadSignatureOptions.CountCollisions. Memory is only needed for
+// the distinct weak sums.
+type collisionCounter struct {
+	entryLen int64 // 0 if the header is unusable
+	pos      int64 // position within the block entries
+	weak     [4]byte
+	buckets  map[uint32]int
+}
+
+// start sets up counting for the entries following head.
+func (c *collisionCounter) start(head []byte) {
+	c.buckets = make(map[uint32]int)
+	if h, err := parseSigHeader(head); err == nil && h.check() == nil {
+		c.entryLen = int64(h.entryLen())
+	}
+}
+
+// write counts the weak sums in p, which continues the block entries.
+func (c *collisionCounter) write(p []byte) {
+	if c.entryLen == 0 {
+		return
+	}
+
+	for len(p) > 0 {
+		off := c.pos % c.entryLen
+		if off < 4 {
+			n := copy(c.weak[off:], p)
+			if off+int64(n) == 4 {
+				c.buckets[binary.BigEndian.Uint32(c.weak[:])]++
+			}
+			c.pos += int64(n)
+			p = p[n:]
+			continue
+		}
+
+		// Skip the strong sum.
+		skip := c.entryLen - off
+		if skip > int64(len(p)) {
+			skip = int64(len(p))
+		}
+		c.pos += skip
+		p = p[skip:]
+	}
+}
+
+func (c *collisionCounter) stats() (stats CollisionStats) {
+	stats.Buckets = len(c.buckets)
+	for _, n := range c.buckets {
+		stats.Blocks += n
+		if n > 1 {
+			stats.CollidingBuckets++
+			stats.CollidingBlocks += n
+		}
+		if n > stats.LargestBucket {
+			stats.LargestBucket = n
+		}
+	}
+	return
+}
+
+// SignatureInfo describes the parameters of a serialized signature.
+type SignatureInfo struct {
+	Hash       HashAlgorithm // DefaultHash, if the magic number is unknown
+	Magic      uint32
+	BlockLen   uint
+	StrongLen  uint
+	BlockCount int64
+}
+
+// InspectSignature reads the parameters of a serialized signature. Unlike
+// LoadSignature, it does not build the structures needed for delta
+// generation, so it is cheap in both memory and time. The input is read to the
+// end to count the blocks.
+func InspectSignature(input io.Reader) (info SignatureInfo, err error) {
+	var buf [sigHeaderLen]byte
+	if _, err = io.ReadFull(input, buf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInputEnded
+		}
+		return
+	}
+
+	h, _ := parseSigHeader(buf[:])
+	info.Hash = hashByMagic(h.magic)
+	if err = h.check(); err != nil {
+		return
+	}
+	info.Magic = h.magic
+	info.BlockLen = uint(h.blockLen)
+	info.StrongLen = uint(h.strongLen)
+
+	n, err := io.Copy(io.Discard, input)
+	if err != nil {
+		return
+	}
+	if n%int64(h.entryLen()) != 0 {
+		return info, ErrCorrupt
+	}
+	info.BlockCount = n / int64(h.entryLen())
+	return
+}
+
+// BlockHash holds the checksums of one block of a basis.
+type BlockHash struct {
+	Weak   uint32 // rolling checksum of the block
+	Strong []byte // strong sum, at least as long as the signature's strong length
+}
+
+// BuildSignature creates a signature from checksums computed elsewhere, e.g.
+// stored by another system, so deltas can be generated against them. The
+// blocks are in the order of the basis, and their checksums must be computed
+// exactly as librsync does for the algorithm hash, including the rolling
+// checksum. Strong sums longer than strongLen are truncated, as librsync uses
+// prefixes of the full digest.
+//
+// The signature is serialized and loaded like one read by LoadSignature, so
+// it has to be closed after use.
+func BuildSignature(blockLen, strongLen uint, hash HashAlgorithm, blocks []BlockHash) (Signature, error) {
+	hash = hash.resolve(false)
+	magic, err := hash.magic()
+	if err != nil {
+		return Signature{}, err
+	}
+	if blockLen == 0 || blockLen > maxBlockLen {
+		return Signature{}, fmt.Errorf("Invalid block length %d", blockLen)
+	}
+	if strongLen == 0 || strongLen > hash.maxStrongLen() {
+		return Signature{}, fmt.Errorf("Invalid strong sum length %d for hash algorithm %d", strongLen, hash)
+	}
+
+	raw := make([]byte, sigHeaderLen, sigHeaderLen+len(blocks)*(4+int(strongLen)))
+	binary.BigEndian.PutUint32(raw[0:], magic)
+	binary.BigEndian.PutUint32(raw[4:], uint32(blockLen))
+	binary.BigEndian.PutUint32(raw[8:], uint32(strongLen))
+
+	for i, b := range blocks {
+		if uint(len(b.Strong)) < strongLen {
+			return Signature{}, fmt.Errorf("Strong sum of block %d is shorter than %d bytes", i, strongLen)
+		}
+		raw = binary.BigEndian.AppendUint32(raw, b.Weak)
+		raw = append(raw, b.Strong[:strongLen]...)
+	}
+
+	return LoadSignature(bytes.NewReader(raw))
+}
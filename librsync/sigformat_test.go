@@ -0,0 +1,130 @@
+package librsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"testing"
+)
+
+func TestCollisionStats(t *testing.T) {
+	for _, raw := range testdata.RandomDataSig() {
+		sig, err := LoadSignatureWithOptions(bytes.NewReader(raw), LoadSignatureOptions{CountCollisions: true})
+		if err != nil {
+			t.Fatalf("LoadSignatureWithOptions failed: %s", err)
+		}
+		stats, err := sig.CollisionStats()
+		sig.Close()
+		if err != nil {
+			t.Fatalf("CollisionStats failed: %s", err)
+		}
+
+		if stats.Blocks != 4 || stats.Buckets != 4 || stats.CollidingBuckets != 0 || stats.LargestBucket != 1 {
+			t.Errorf("unexpected stats for 4 distinct blocks: %+v", stats)
+		}
+	}
+
+	sig, err := LoadSignature(bytes.NewReader(testdata.RandomDataSig()[0]))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer sig.Close()
+	if _, err := sig.CollisionStats(); err != ErrNoCollisionStats {
+		t.Errorf("expected ErrNoCollisionStats without counting, got %v", err)
+	}
+}
+
+func TestCollisionCounter(t *testing.T) {
+	raw := []byte{
+		0x72, 0x73, 0x01, 0x36, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x01, 0xaa, 0xaa,
+		0x00, 0x00, 0x00, 0x02, 0xbb, 0xbb,
+		0x00, 0x00, 0x00, 0x01, 0xcc, 0xcc,
+	}
+	expected := CollisionStats{Blocks: 3, Buckets: 2, CollidingBuckets: 1, CollidingBlocks: 2, LargestBucket: 2}
+
+	// Weak sums split across reads are counted as well.
+	for _, chunk := range []int{1, 3, 5, len(raw)} {
+		rec := &sigRecorder{r: bytes.NewReader(raw), collisions: new(collisionCounter)}
+		if _, err := readChunked(rec, chunk); err != nil {
+			t.Fatalf("reading failed: %s", err)
+		}
+		if stats := rec.collisions.stats(); stats != expected {
+			t.Errorf("chunks of %d bytes: expected %+v, got %+v", chunk, expected, stats)
+		}
+	}
+
+	// A header claiming huge strong sums is not used to count anything.
+	hostile := append([]byte{0x72, 0x73, 0x01, 0x36, 0x00, 0x00, 0x08, 0x00, 0xff, 0xff, 0xff, 0xff}, raw[sigHeaderLen:]...)
+	rec := &sigRecorder{r: bytes.NewReader(hostile), collisions: new(collisionCounter)}
+	if _, err := readChunked(rec, 7); err != nil {
+		t.Fatalf("reading failed: %s", err)
+	}
+	if stats := rec.collisions.stats(); stats != (CollisionStats{}) {
+		t.Errorf("counted entries of a hostile header: %+v", stats)
+	}
+}
+
+func TestInspectSignature(t *testing.T) {
+	expected := []SignatureInfo{
+		{Hash: MD4, Magic: 0x72730136, BlockLen: 2048, StrongLen: 8, BlockCount: 4},
+		{Hash: BLAKE2, Magic: 0x72730137, BlockLen: 2048, StrongLen: 32, BlockCount: 4},
+	}
+	for i, raw := range testdata.RandomDataSig() {
+		info, err := InspectSignature(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("InspectSignature failed: %s", err)
+		}
+		if info != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], info)
+		}
+	}
+
+	if _, err := InspectSignature(bytes.NewReader(testdata.Delta())); err != ErrBadMagic {
+		t.Errorf("expected ErrBadMagic for a delta, got %v", err)
+	}
+	if _, err := InspectSignature(bytes.NewReader(testdata.RandomDataSig()[0][:20])); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt for a truncated signature, got %v", err)
+	}
+}
+
+func TestBuildSignature(t *testing.T) {
+	raw := testdata.RandomDataSig()[0]
+	h, entries, err := sigBlocks(raw)
+	if err != nil {
+		t.Fatalf("sigBlocks failed: %s", err)
+	}
+
+	var blocks []BlockHash
+	for i := 0; i < len(entries); i += h.entryLen() {
+		blocks = append(blocks, BlockHash{
+			Weak:   binary.BigEndian.Uint32(entries[i:]),
+			Strong: entries[i+4 : i+h.entryLen()],
+		})
+	}
+
+	sig, err := BuildSignature(uint(h.blockLen), uint(h.strongLen), MD4, blocks)
+	if err != nil {
+		t.Fatalf("BuildSignature failed: %s", err)
+	}
+	defer sig.Close()
+
+	if n, _ := sig.BlockCount(); n != 4 || sig.BlockLen() != 2048 || sig.StrongLen() != 8 || sig.HashAlgorithm() != MD4 {
+		t.Errorf("unexpected parameters: %d blocks of %d bytes, strong length %d, hash %d", n, sig.BlockLen(), sig.StrongLen(), sig.HashAlgorithm())
+	}
+
+	delta, err := DeltaSize(sig, bytes.NewReader(testdata.Mutation()))
+	if err != nil {
+		t.Fatalf("DeltaSize failed: %s", err)
+	}
+	if delta != int64(len(testdata.Delta())) {
+		t.Errorf("expected a delta of %d bytes, got %d", len(testdata.Delta()), delta)
+	}
+
+	if _, err := BuildSignature(uint(h.blockLen), 17, MD4, blocks); err == nil {
+		t.Errorf("BuildSignature accepted a strong length beyond the MD4 digest size")
+	}
+	if _, err := BuildSignature(uint(h.blockLen), 16, MD4, blocks); err == nil {
+		t.Errorf("BuildSignature accepted strong sums shorter than the strong length")
+	}
+}
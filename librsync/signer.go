@@ -0,0 +1,156 @@
+package librsync
+
+import (
+	"io"
+)
+
+// pipeSigner feeds the data written to it into a signature generation job that
+// runs in its own goroutine. This turns the pull based Job into a writer.
+type pipeSigner struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error // result of the job, valid once done is closed
+}
+
+// startSigner starts a signature generation job over the data written to the
+// returned pipeSigner. consume gets the job and has to read it until the end.
+func startSigner(config Config, consume func(siggen io.Reader) error) *pipeSigner {
+	pr, pw := io.Pipe()
+	s := &pipeSigner{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		siggen, err := NewSignatureGen(config, pr)
+		if err == nil {
+			err = consume(siggen)
+			siggen.Close()
+		}
+		s.err = err
+
+		// Don't leave writers blocked if the job stopped early.
+		pr.CloseWithError(err)
+	}()
+
+	return s
+}
+
+func (s *pipeSigner) Write(p []byte) (int, error) {
+	return s.pw.Write(p)
+}
+
+// finish signals the end of the data and waits for the job to complete. A
+// non-nil err aborts the job instead. finish returns the error of the job.
+func (s *pipeSigner) finish(err error) error {
+	s.pw.CloseWithError(err)
+	<-s.done
+	return s.err
+}
+
+type writeThroughSigner struct {
+	dst    io.Writer
+	signer *pipeSigner
+	sig    Signature
+	err    error
+}
+
+// WriteThroughSigner returns a writer that writes everything to dst and at the
+// same time generates a signature of the written data, so it does not have to
+// be read a second time.
+//
+// Once all data is written, call the returned function to get the loaded
+// signature. It returns the first error that occurred writing to dst or
+// generating the signature. It must also be called after a failed write, to
+// free the resources of the signature generation.
+func WriteThroughSigner(dst io.Writer, config Config) (io.Writer, func() (Signature, error)) {
+	w := &writeThroughSigner{dst: dst}
+	w.signer = startSigner(config, func(siggen io.Reader) (err error) {
+		w.sig, err = LoadSignature(siggen)
+		return
+	})
+	return w, w.finish
+}
+
+func (w *writeThroughSigner) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.dst.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		_, err = w.signer.Write(p)
+	}
+
+	w.err = err
+	return n, err
+}
+
+func (w *writeThroughSigner) finish() (Signature, error) {
+	err := w.signer.finish(w.err)
+	if w.err != nil {
+		err = w.err
+	}
+	if err != nil {
+		w.sig.Close()
+		return Signature{}, err
+	}
+	return w.sig, nil
+}
+
+type teeSignature struct {
+	basis  io.Reader
+	signer *pipeSigner // nil once finished
+	err    error       // final result, once finished
+}
+
+// TeeSignature returns a reader that yields the data of basis, while the
+// signature of the data read through it is generated with the default
+// parameters and written to sigOut.
+//
+// The signature is only complete once the returned reader returned io.EOF.
+// An error generating or writing the signature is returned by the final Read
+// instead of io.EOF. The reader must be read until it returns an error, to
+// release the signature generation.
+func TeeSignature(basis io.Reader, sigOut io.Writer) io.Reader {
+	return &teeSignature{
+		basis: basis,
+		signer: startSigner(Config{}, func(siggen io.Reader) error {
+			_, err := io.Copy(sigOut, siggen)
+			return err
+		}),
+	}
+}
+
+func (t *teeSignature) Read(p []byte) (int, error) {
+	if t.signer == nil {
+		return 0, t.err
+	}
+
+	n, err := t.basis.Read(p)
+	if n > 0 {
+		if _, werr := t.signer.Write(p[:n]); werr != nil {
+			err = werr
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		if serr := t.signer.finish(nil); serr != nil {
+			err = serr
+		}
+	case err != nil:
+		t.signer.finish(err)
+	default:
+		return n, nil
+	}
+
+	t.signer = nil
+	t.err = err
+	return n, err
+}
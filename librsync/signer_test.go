@@ -0,0 +1,126 @@
+package librsync
+
+import (
+	"bytes"
+	"errors"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTeeSignature(t *testing.T) {
+	expected, err := SignatureBytes(testdata.RandomData(), Config{})
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+
+	sig := new(bytes.Buffer)
+	data, err := io.ReadAll(TeeSignature(bytes.NewReader(testdata.RandomData()), sig))
+	if err != nil {
+		t.Fatalf("reading through TeeSignature failed: %s", err)
+	}
+	if !bytes.Equal(data, testdata.RandomData()) {
+		t.Errorf("data read through TeeSignature differs from the basis")
+	}
+	if !bytes.Equal(sig.Bytes(), expected) {
+		t.Errorf("signature differs from the one of SignatureBytes")
+	}
+
+	tee := TeeSignature(iotest.TimeoutReader(bytes.NewReader(testdata.RandomData())), io.Discard)
+	if _, err := io.ReadAll(tee); err != iotest.ErrTimeout {
+		t.Errorf("expected the error of the basis, got %v", err)
+	}
+}
+
+// failingWriter fails once more than n bytes were written to it.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteThroughSigner(t *testing.T) {
+	config := Config{BlockLen: 2048, StrongLen: 8, Hash: MD4}
+	raw, err := SignatureBytes(testdata.RandomData(), config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+	expected, err := LoadSignature(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("LoadSignature failed: %s", err)
+	}
+	defer expected.Close()
+
+	dst := new(bytes.Buffer)
+	w, finish := WriteThroughSigner(dst, config)
+	data := testdata.RandomData()
+	for off := 0; off < len(data); off += 1000 {
+		end := off + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(data[off:end]); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+	}
+	sig, err := finish()
+	if err != nil {
+		t.Fatalf("finishing the signature failed: %s", err)
+	}
+	defer sig.Close()
+
+	if !bytes.Equal(dst.Bytes(), testdata.RandomData()) {
+		t.Errorf("data written to dst differs from the input")
+	}
+
+	gotBlocks, _ := sig.BlockCount()
+	expectedBlocks, _ := expected.BlockCount()
+	if sig.BlockLen() != expected.BlockLen() || sig.StrongLen() != expected.StrongLen() || sig.HashAlgorithm() != expected.HashAlgorithm() || gotBlocks != expectedBlocks {
+		t.Errorf("signature parameters differ from the loaded signature")
+	}
+
+	for _, s := range []Signature{sig, expected} {
+		delta := new(bytes.Buffer)
+		if _, err := writeDelta(s, bytes.NewReader(testdata.Mutation()), delta); err != nil {
+			t.Fatalf("delta generation failed: %s", err)
+		}
+		if !bytes.Equal(delta.Bytes(), testdata.Delta()) {
+			t.Errorf("delta from the signature differs from the expected one")
+		}
+	}
+}
+
+func TestWriteThroughSignerWriteError(t *testing.T) {
+	writeErr := errors.New("disk full")
+	w, finish := WriteThroughSigner(&failingWriter{n: 3000, err: writeErr}, Config{})
+
+	data := testdata.RandomData()
+	if _, err := w.Write(data[:2000]); err != nil {
+		t.Fatalf("first Write failed: %s", err)
+	}
+	if n, err := w.Write(data[2000:4000]); err != writeErr || n != 1000 {
+		t.Errorf("expected 1000 bytes and the error of dst, got %d, %v", n, err)
+	}
+	if _, err := w.Write(data[4000:]); err != writeErr {
+		t.Errorf("Write after a failed one: expected the error of dst, got %v", err)
+	}
+
+	sig, err := finish()
+	if err != writeErr {
+		t.Errorf("expected the error of dst from finishing, got %v", err)
+	}
+	if sig.signature != nil {
+		sig.Close()
+		t.Errorf("got a signature despite the failed write")
+	}
+}
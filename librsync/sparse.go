@@ -0,0 +1,101 @@
+package librsync
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// Range is a range of bytes within a file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+var ErrRangeOutOfBounds = errors.New("Range lies outside of the file")
+
+// SparseSignature generates the signature of a sparse file of the given size,
+// in which only ranges hold data. The holes in between are treated as zeros
+// without reading them from basis, which is useful for e.g. VM disk images.
+//
+// The output is exactly the signature of the whole file with its holes read as
+// zeros, which is how sparse files read anyway. So the signature works with
+// regular delta generation, and the file itself can be used as the basis for
+// patching.
+func SparseSignature(basis io.ReaderAt, ranges []Range, size int64, config Config, signature io.Writer) error {
+	r, err := newSparseReader(basis, ranges, size)
+	if err != nil {
+		return err
+	}
+
+	siggen, err := NewSignatureGen(config, r)
+	if err != nil {
+		return err
+	}
+	defer siggen.Close()
+
+	_, err = io.Copy(signature, siggen)
+	return err
+}
+
+// sparseReader reads a file of which only some ranges hold data. Everything
+// else reads as zeros.
+type sparseReader struct {
+	basis  io.ReaderAt
+	ranges []Range // sorted by offset
+	size   int64
+	pos    int64
+}
+
+func newSparseReader(basis io.ReaderAt, ranges []Range, size int64) (*sparseReader, error) {
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	for _, r := range sorted {
+		if r.Offset < 0 || r.Length < 0 || r.Offset+r.Length > size {
+			return nil, ErrRangeOutOfBounds
+		}
+	}
+
+	return &sparseReader{basis: basis, ranges: sorted, size: size}, nil
+}
+
+func (s *sparseReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if remaining := s.size - s.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	for len(s.ranges) > 0 && s.ranges[0].Offset+s.ranges[0].Length <= s.pos {
+		s.ranges = s.ranges[1:]
+	}
+
+	if len(s.ranges) == 0 || s.ranges[0].Offset > s.pos {
+		// In a hole, up to the next range.
+		if len(s.ranges) > 0 && s.ranges[0].Offset-s.pos < int64(len(p)) {
+			p = p[:s.ranges[0].Offset-s.pos]
+		}
+		for i := range p {
+			p[i] = 0
+		}
+		s.pos += int64(len(p))
+		return len(p), nil
+	}
+
+	if end := s.ranges[0].Offset + s.ranges[0].Length; end-s.pos < int64(len(p)) {
+		p = p[:end-s.pos]
+	}
+
+	n, err := s.basis.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF {
+		if n < len(p) {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = nil
+		}
+	}
+	return n, err
+}
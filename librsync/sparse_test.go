@@ -0,0 +1,69 @@
+package librsync
+
+import (
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"testing"
+)
+
+func TestSparseReader(t *testing.T) {
+	data := testdata.RandomData()
+	ranges := []Range{{5000, 1000}, {0, 100}, {5500, 1000}, {8000, 192}}
+
+	expected := make([]byte, len(data))
+	for _, r := range ranges {
+		copy(expected[r.Offset:r.Offset+r.Length], data[r.Offset:])
+	}
+
+	r, err := newSparseReader(bytes.NewReader(data), ranges, int64(len(data)))
+	if err != nil {
+		t.Fatalf("newSparseReader failed: %s", err)
+	}
+	got, err := readChunked(r, 333)
+	if err != nil {
+		t.Fatalf("reading failed: %s", err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("sparse reader does not read holes as zeros")
+	}
+
+	if _, err := newSparseReader(bytes.NewReader(data), []Range{{8000, 200}}, int64(len(data))); err != ErrRangeOutOfBounds {
+		t.Errorf("expected %s, got %v", ErrRangeOutOfBounds, err)
+	}
+
+	r, err = newSparseReader(bytes.NewReader(data[:100]), []Range{{0, 200}}, 300)
+	if err != nil {
+		t.Fatalf("newSparseReader failed: %s", err)
+	}
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("short basis: expected %s, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestSparseSignature(t *testing.T) {
+	config := Config{BlockLen: 512, StrongLen: 8, Hash: MD4}
+
+	// The basis has data in the holes as well, which must not be read. The
+	// file extends beyond the basis with a trailing hole.
+	data := testdata.RandomData()
+	ranges := []Range{{5000, 1000}, {0, 100}, {5500, 1000}, {8000, 192}}
+	size := int64(len(data) + 1000)
+
+	zeroFilled := make([]byte, size)
+	for _, r := range ranges {
+		copy(zeroFilled[r.Offset:r.Offset+r.Length], data[r.Offset:])
+	}
+	expected, err := SignatureBytes(zeroFilled, config)
+	if err != nil {
+		t.Fatalf("SignatureBytes failed: %s", err)
+	}
+
+	sig := new(bytes.Buffer)
+	if err := SparseSignature(bytes.NewReader(data), ranges, size, config, sig); err != nil {
+		t.Fatalf("SparseSignature failed: %s", err)
+	}
+	if !bytes.Equal(sig.Bytes(), expected) {
+		t.Errorf("sparse signature differs from the signature of the zero-filled file")
+	}
+}
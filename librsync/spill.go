@@ -0,0 +1,64 @@
+package librsync
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// spillBuffer keeps written data in memory up to a threshold. Once that would
+// be exceeded, all data is moved to a temporary file. Close removes the file.
+type spillBuffer struct {
+	threshold int64
+	mem       bytes.Buffer
+	file      *os.File
+	size      int64
+}
+
+func newSpillBuffer(threshold int64) *spillBuffer {
+	return &spillBuffer{threshold: threshold}
+}
+
+func (b *spillBuffer) Write(p []byte) (n int, err error) {
+	if b.file == nil && int64(b.mem.Len()+len(p)) > b.threshold {
+		if b.file, err = os.CreateTemp("", "golibrsync"); err != nil {
+			return 0, err
+		}
+		if _, err = b.file.Write(b.mem.Bytes()); err != nil {
+			return 0, err
+		}
+		b.mem = bytes.Buffer{}
+	}
+
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return
+}
+
+// reader returns a reader over everything written so far.
+func (b *spillBuffer) reader() *io.SectionReader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return io.NewSectionReader(bytes.NewReader(b.mem.Bytes()), 0, b.size)
+}
+
+// Close frees the buffered data and removes the temporary file, if any.
+func (b *spillBuffer) Close() error {
+	b.mem = bytes.Buffer{}
+	if b.file == nil {
+		return nil
+	}
+
+	name := b.file.Name()
+	err := b.file.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	b.file = nil
+	return err
+}
@@ -0,0 +1,41 @@
+package librsync
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	for _, threshold := range []int64{0, 10, 500, 2000} {
+		buf := newSpillBuffer(threshold)
+		for i := 0; i < len(data); i += 30 {
+			end := i + 30
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := buf.Write(data[i:end]); err != nil {
+				t.Fatalf("threshold %d: write failed: %s", threshold, err)
+			}
+		}
+
+		spilled := buf.file != nil
+		if spilled != (threshold < int64(len(data))) {
+			t.Errorf("threshold %d: spilled = %t", threshold, spilled)
+		}
+
+		got, err := io.ReadAll(buf.reader())
+		if err != nil {
+			t.Fatalf("threshold %d: read failed: %s", threshold, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("threshold %d: data read back does not match", threshold)
+		}
+
+		if err := buf.Close(); err != nil {
+			t.Errorf("threshold %d: close failed: %s", threshold, err)
+		}
+	}
+}
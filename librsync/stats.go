@@ -0,0 +1,58 @@
+package librsync
+
+/*
+#include <stdio.h>
+#include <librsync.h>
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+var ErrJobRunning = errors.New("Job is still running")
+
+// Stats holds the statistics librsync collects while running a job.
+type Stats struct {
+	LitCmds   int64 // number of literal commands
+	LitBytes  int64 // number of literal bytes
+	CopyCmds  int64 // number of copy commands
+	CopyBytes int64 // number of bytes copied from the basis
+	SigBlocks int64 // number of blocks described by the signature
+	InBytes   int64 // total bytes consumed
+	OutBytes  int64 // total bytes produced
+}
+
+// Stats returns the statistics of a job, e.g. the literal and copied bytes of a
+// delta. The job must have finished, but not yet been closed.
+func (job *Job) Stats() (Stats, error) {
+	if job.running {
+		return Stats{}, ErrJobRunning
+	}
+	if job.job == nil {
+		return Stats{}, errors.New("Job is closed")
+	}
+	return job.stats(), nil
+}
+
+func (job *Job) stats() Stats {
+	s := C.rs_job_statistics(job.job)
+	stats := Stats{
+		LitCmds:   int64(s.lit_cmds),
+		LitBytes:  int64(s.lit_bytes),
+		CopyCmds:  int64(s.copy_cmds),
+		CopyBytes: int64(s.copy_bytes),
+		SigBlocks: int64(s.sig_blocks),
+		InBytes:   int64(s.in_bytes),
+		OutBytes:  int64(s.out_bytes),
+	}
+
+	// librsync did not see the prefix of a hinted delta.
+	if h := job.hint; h != nil {
+		stats.CopyCmds++
+		stats.CopyBytes += h.prefix
+		stats.InBytes += h.prefix
+		stats.OutBytes += int64(len(h.head)) - 4
+	}
+	return stats
+}
@@ -1,7 +1,7 @@
 package testdata
 
 func Delta() []byte {
-	// Was generated with `rdiff delta random_data.sig mutation delta`
+	// Regenerate with `go generate`, see gengolden.go
 	return []byte{
 		0x72, 0x73, 0x02, 0x36, 0x42, 0x08, 0x06, 0x5d, 0x10, 0x22, 0xd3, 0x3c,
 		0x6a, 0xeb, 0x28, 0xf7, 0xa2, 0x24, 0x56, 0xda, 0x14, 0xa2, 0x82, 0x66,
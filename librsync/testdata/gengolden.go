@@ -0,0 +1,124 @@
+//go:build gengolden
+
+// Command gengolden regenerates the golden signatures and delta from
+// random_data and mutation with the linked librsync. It writes both the raw
+// files and their Go source. Run it with go generate in the testdata directory.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/silvasur/golibrsync/librsync"
+	"io"
+	"log"
+	"os"
+)
+
+const generatedComment = "Regenerate with `go generate`, see gengolden.go"
+
+func main() {
+	log.SetFlags(0)
+
+	data := readFile("random_data")
+	mutation := readFile("mutation")
+
+	sigMD4 := signature(librsync.Config{BlockLen: 2048, StrongLen: 8, Hash: librsync.MD4}, data)
+	sigBLAKE2 := signature(librsync.Config{BlockLen: 2048, StrongLen: 32, Hash: librsync.BLAKE2}, data)
+	delta := createDelta(sigMD4, mutation)
+
+	writeFile("random_data.sig", sigMD4)
+	writeFile("random_data.sig2", sigBLAKE2)
+	writeFile("delta", delta)
+
+	writeFile("random_data.go", goSource("RandomData", "", data))
+	writeFile("mutation.go", goSource("Mutation", "", mutation))
+	writeFile("random_data.sig.go", goSource("RandomDataSig", generatedComment, sigMD4, sigBLAKE2))
+	writeFile("delta.go", goSource("Delta", generatedComment, delta))
+}
+
+func readFile(name string) []byte {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+func writeFile(name string, b []byte) {
+	if err := os.WriteFile(name, b, 0664); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func signature(config librsync.Config, data []byte) []byte {
+	siggen, err := librsync.NewSignatureGen(config, bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("NewSignatureGen: %s", err)
+	}
+	defer siggen.Close()
+
+	sig, err := io.ReadAll(siggen)
+	if err != nil {
+		log.Fatalf("generating signature: %s", err)
+	}
+	return sig
+}
+
+func createDelta(sig, newfile []byte) []byte {
+	delta, err := librsync.DeltaBytes(sig, newfile)
+	if err != nil {
+		log.Fatalf("generating delta: %s", err)
+	}
+	return delta
+}
+
+// goSource returns the source of a function returning the given data, a
+// []byte for one slice or a [][]byte for several.
+func goSource(name, comment string, data ...[]byte) []byte {
+	var buf bytes.Buffer
+	typ := "[]byte"
+	if len(data) > 1 {
+		typ = "[][]byte"
+	}
+	fmt.Fprintf(&buf, "package testdata\n\nfunc %s() %s {\n", name, typ)
+	if comment != "" {
+		fmt.Fprintf(&buf, "\t// %s\n", comment)
+	}
+	fmt.Fprintf(&buf, "\treturn %s{\n", typ)
+
+	if len(data) == 1 {
+		writeBytes(&buf, "\t\t", data[0])
+	} else {
+		for i, b := range data {
+			if i == 0 {
+				buf.WriteString("\t\t{\n")
+			} else {
+				buf.WriteString("\t\t}, {\n")
+			}
+			writeBytes(&buf, "\t\t\t", b)
+		}
+		buf.WriteString("\t\t},\n")
+	}
+
+	buf.WriteString("\t}\n}\n")
+	return buf.Bytes()
+}
+
+// writeBytes writes b as hex literals, twelve per line.
+func writeBytes(buf *bytes.Buffer, indent string, b []byte) {
+	for len(b) > 0 {
+		n := len(b)
+		if n > 12 {
+			n = 12
+		}
+		buf.WriteString(indent)
+		for i, c := range b[:n] {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			fmt.Fprintf(buf, "0x%02x,", c)
+		}
+		buf.WriteByte('\n')
+		b = b[n:]
+	}
+}
@@ -1,7 +1,7 @@
 package testdata
 
 func RandomDataSig() [][]byte {
-	// Was generated with `rdiff signature random_data random_data.sig`
+	// Regenerate with `go generate`, see gengolden.go
 	return [][]byte{
 		{
 			0x72, 0x73, 0x01, 0x36, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x08,
@@ -0,0 +1,10 @@
+// Package testdata holds the golden files the tests of librsync compare
+// against: random data, a mutation of it, signatures of the random data and a
+// delta from it to the mutation.
+//
+// The signatures and the delta depend on the output of librsync. If a new
+// version changes it, regenerate them with the linked librsync by running go
+// generate in this directory, or go test -update in the librsync directory.
+package testdata
+
+//go:generate go run -tags gengolden gengolden.go
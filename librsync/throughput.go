@@ -0,0 +1,56 @@
+package librsync
+
+import (
+	"io"
+	"time"
+)
+
+// ThrottleReporter wraps a reader and periodically reports the rate at which
+// data was read from it during the last interval, e.g. to show MB/s during a
+// long running job. Wrap the input of a job with it to measure its throughput.
+//
+// Reports happen from within Read, timed by the monotonic clock. No goroutine
+// is involved, so a reader that is not being read does not report either.
+type ThrottleReporter struct {
+	R        io.Reader
+	Interval time.Duration             // time between reports, one second if zero
+	Report   func(bytesPerSec float64) // called with the rate, may be nil
+
+	start time.Time // start of the current interval
+	n     int64     // bytes read in the current interval
+	now   func() time.Time
+}
+
+func (t *ThrottleReporter) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *ThrottleReporter) Read(p []byte) (int, error) {
+	if t.Report == nil {
+		return t.R.Read(p)
+	}
+
+	if t.start.IsZero() {
+		t.start = t.clock()
+	}
+
+	n, err := t.R.Read(p)
+	t.n += int64(n)
+
+	interval := t.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	now := t.clock()
+	if elapsed := now.Sub(t.start); elapsed >= interval {
+		t.Report(float64(t.n) / elapsed.Seconds())
+		t.start = now
+		t.n = 0
+	}
+
+	return n, err
+}
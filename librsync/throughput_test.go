@@ -0,0 +1,51 @@
+package librsync
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// slowReader advances a fake clock on every read.
+type slowReader struct {
+	r     io.Reader
+	now   *time.Time
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	*s.now = s.now.Add(s.delay)
+	return s.r.Read(p)
+}
+
+func TestThrottleReporter(t *testing.T) {
+	now := time.Unix(0, 0)
+	var rates []float64
+
+	r := &ThrottleReporter{
+		R:        slowReader{bytes.NewReader(make([]byte, 1000)), &now, 500 * time.Millisecond},
+		Interval: time.Second,
+		Report:   func(rate float64) { rates = append(rates, rate) },
+		now:      func() time.Time { return now },
+	}
+
+	buf := make([]byte, 100)
+	for {
+		_, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Each read of 100 bytes takes half a second, so every other read should
+	// report 200 bytes per second.
+	if len(rates) != 5 {
+		t.Fatalf("expected 5 reports, got %d", len(rates))
+	}
+	for _, rate := range rates {
+		if rate != 200 {
+			t.Errorf("expected a rate of 200 bytes/s, got %f", rate)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package librsync
+
+/*
+#include <stdio.h>
+#include <librsync.h>
+
+void traceCallbackGo(int level, char *msg);
+
+static void traceCallback(rs_loglevel level, char const *msg) {
+	traceCallbackGo((int) level, (char*) msg);
+}
+
+static inline void trace_to_go(int enable) {
+	rs_trace_to(enable ? traceCallback : NULL);
+}
+*/
+import "C"
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// TraceLevel is the verbosity of librsync's diagnostic messages.
+type TraceLevel int
+
+const (
+	TraceError   TraceLevel = C.RS_LOG_ERR
+	TraceWarning TraceLevel = C.RS_LOG_WARNING
+	TraceNotice  TraceLevel = C.RS_LOG_NOTICE
+	TraceInfo    TraceLevel = C.RS_LOG_INFO
+	TraceDebug   TraceLevel = C.RS_LOG_DEBUG // only if librsync was built with tracing
+)
+
+var traceWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func init() {
+	// librsync writes to stderr by default, be silent instead.
+	C.trace_to_go(0)
+}
+
+// SetTraceLevel sets the level up to which librsync's diagnostic messages are
+// passed to the writer set with SetTraceWriter.
+func SetTraceLevel(level TraceLevel) {
+	C.rs_trace_set_level(C.rs_loglevel(level))
+}
+
+// SetTraceWriter makes librsync write its diagnostic messages to w, one per
+// line. If w is nil, the messages are dropped, which is the default.
+func SetTraceWriter(w io.Writer) {
+	traceWriter.lock.Lock()
+	defer traceWriter.lock.Unlock()
+
+	traceWriter.w = w
+	if w == nil {
+		C.trace_to_go(0)
+	} else {
+		C.trace_to_go(1)
+	}
+}
+
+// writeTrace writes msg as a single line. librsync ends its messages with a
+// newline already, which is not doubled.
+func writeTrace(msg string) {
+	traceWriter.lock.Lock()
+	defer traceWriter.lock.Unlock()
+
+	if traceWriter.w != nil {
+		io.WriteString(traceWriter.w, strings.TrimSuffix(msg, "\n")+"\n")
+	}
+}
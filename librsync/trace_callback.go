@@ -0,0 +1,8 @@
+package librsync
+
+import "C"
+
+//export traceCallbackGo
+func traceCallbackGo(level C.int, msg *C.char) {
+	writeTrace(C.GoString(msg))
+}
@@ -0,0 +1,29 @@
+package librsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	SetTraceWriter(&buf)
+	SetTraceLevel(TraceInfo)
+	defer func() {
+		SetTraceWriter(nil)
+		SetTraceLevel(TraceInfo)
+	}()
+
+	writeTrace("librsync: a message\n")
+	writeTrace("librsync: without newline")
+	if buf.String() != "librsync: a message\nlibrsync: without newline\n" {
+		t.Errorf("unexpected trace output %q", buf.String())
+	}
+
+	SetTraceWriter(nil)
+	writeTrace("dropped")
+	if strings.Contains(buf.String(), "dropped") {
+		t.Errorf("message was written after removing the writer")
+	}
+}
@@ -0,0 +1,140 @@
+package librsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SignatureTree and DeltaTree work on whole directory trees. Their archives
+// are tar files with one entry per regular file, named by the slash separated
+// path relative to the root, holding the signature or delta of that file.
+// Other kinds of files (directories, symlinks, ...) are not included.
+
+// SignatureTree writes an archive with the signatures of all regular files
+// below root to w.
+func SignatureTree(root string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	buf := new(bytes.Buffer)
+
+	err := walkTree(root, func(name, file string) error {
+		buf.Reset()
+		if err := fileSignature(file, buf); err != nil {
+			return err
+		}
+		return writeTreeEntry(tw, name, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// DeltaTree reads an archive created by SignatureTree and writes an archive
+// with the deltas of all regular files below root against it to w. Files
+// without a signature in the archive get a delta against an empty file. Files
+// that only have a signature do not get an entry.
+func DeltaTree(sigArchive io.Reader, root string, w io.Writer) error {
+	sigs := make(map[string][]byte)
+	tr := tar.NewReader(sigArchive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		sig, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		sigs[path.Clean(hdr.Name)] = sig
+	}
+
+	var empty bytes.Buffer
+	if err := CreateSignature(bytes.NewReader(nil), &empty); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	buf := new(bytes.Buffer)
+
+	err := walkTree(root, func(name, file string) error {
+		sig, ok := sigs[name]
+		if !ok {
+			sig = empty.Bytes()
+		}
+
+		buf.Reset()
+		if err := fileDelta(bytes.NewReader(sig), file, buf); err != nil {
+			return err
+		}
+		return writeTreeEntry(tw, name, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// walkTree calls fn for every regular file below root, in lexical order, with
+// the archive name and the path of the file.
+func walkTree(root string, fn func(name, file string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), path)
+	})
+}
+
+func fileSignature(path string, sig io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return CreateSignature(f, sig)
+}
+
+func fileDelta(sig io.Reader, path string, delta io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return CreateDelta(sig, f, delta)
+}
+
+func writeTreeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
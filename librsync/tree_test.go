@@ -0,0 +1,74 @@
+package librsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/silvasur/golibrsync/librsync/testdata"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignatureAndDeltaTree(t *testing.T) {
+	oldRoot := t.TempDir()
+	newRoot := t.TempDir()
+
+	write := func(root, name string, data []byte) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(oldRoot, "sub/file", testdata.RandomData())
+	write(oldRoot, "removed", testdata.RandomData())
+	write(newRoot, "sub/file", testdata.Mutation())
+	write(newRoot, "added", testdata.Mutation())
+
+	sigs := new(bytes.Buffer)
+	if err := SignatureTree(oldRoot, sigs); err != nil {
+		t.Fatalf("SignatureTree failed: %s", err)
+	}
+
+	deltas := new(bytes.Buffer)
+	if err := DeltaTree(sigs, newRoot, deltas); err != nil {
+		t.Fatalf("DeltaTree failed: %s", err)
+	}
+
+	bases := map[string][]byte{
+		"added":    nil,
+		"sub/file": testdata.RandomData(),
+	}
+
+	tr := tar.NewReader(deltas)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		basis, ok := bases[hdr.Name]
+		if !ok {
+			t.Errorf("unexpected entry %q", hdr.Name)
+			continue
+		}
+		delete(bases, hdr.Name)
+
+		got := new(bytes.Buffer)
+		if err := Patch(bytes.NewReader(basis), tr, got); err != nil {
+			t.Fatalf("patching %q failed: %s", hdr.Name, err)
+		}
+		if !bytes.Equal(got.Bytes(), testdata.Mutation()) {
+			t.Errorf("patched %q is not equal to the new file", hdr.Name)
+		}
+	}
+	for name := range bases {
+		t.Errorf("missing entry %q", name)
+	}
+}
@@ -0,0 +1,59 @@
+package librsync
+
+/*
+#include <stdio.h>
+#include <librsync.h>
+
+static inline const char* librsync_version() {
+	return rs_librsync_version;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+)
+
+// Feature is an optional capability of librsync, see Supports.
+type Feature int
+
+const (
+	FeatureBLAKE2     Feature = iota // BLAKE2 signatures (librsync >= 1.0.0)
+	FeatureRabinKarp                 // RabinKarp rolling checksums (librsync >= 2.2.0)
+	FeatureSigArgs                   // PredictSignatureArgs (librsync >= 2.2.0)
+	FeatureDebugTrace                // debug level trace messages, see TraceDebug
+)
+
+// LibrsyncVersion returns the version of the linked librsync.
+func LibrsyncVersion() string {
+	return C.GoString(C.librsync_version())
+}
+
+// Supports reports whether the linked librsync supports a feature.
+func Supports(feature Feature) bool {
+	switch feature {
+	case FeatureBLAKE2:
+		return haveBLAKE2
+	case FeatureRabinKarp:
+		return libVersionAtLeast(2, 2)
+	case FeatureSigArgs:
+		return haveSigArgs()
+	case FeatureDebugTrace:
+		return C.rs_supports_trace() != 0
+	}
+	return false
+}
+
+// libVersion is the major and minor version of the linked librsync.
+var libVersion = parseLibVersion(LibrsyncVersion())
+
+func parseLibVersion(version string) (v [2]int) {
+	fmt.Sscanf(version, "%d.%d", &v[0], &v[1])
+	return
+}
+
+// libVersionAtLeast reports whether the linked librsync is at least of the
+// given version.
+func libVersionAtLeast(major, minor int) bool {
+	return libVersion[0] > major || (libVersion[0] == major && libVersion[1] >= minor)
+}
@@ -0,0 +1,35 @@
+package librsync
+
+import (
+	"testing"
+)
+
+func TestParseLibVersion(t *testing.T) {
+	for version, expected := range map[string][2]int{
+		"2.3.2":   {2, 3},
+		"0.9.7":   {0, 9},
+		"1.0":     {1, 0},
+		"garbage": {0, 0},
+	} {
+		if v := parseLibVersion(version); v != expected {
+			t.Errorf("%q: expected %v, got %v", version, expected, v)
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	if LibrsyncVersion() == "" {
+		t.Errorf("empty librsync version")
+	}
+
+	if Supports(FeatureRabinKarp) && !Supports(FeatureBLAKE2) {
+		t.Errorf("RabinKarp supported without BLAKE2")
+	}
+
+	for hash, feature := range map[HashAlgorithm]Feature{BLAKE2: FeatureBLAKE2, RabinKarpMD4: FeatureRabinKarp} {
+		_, err := hash.magic()
+		if Supports(feature) != (err == nil) {
+			t.Errorf("hash %d: Supports and magic disagree: %v", hash, err)
+		}
+	}
+}